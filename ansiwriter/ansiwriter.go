@@ -0,0 +1,95 @@
+package ansiwriter
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/maxzender/jv/jsonfmt"
+	"github.com/nsf/termbox-go"
+)
+
+// ansiWriter writes formatted JSON as text, colored with ANSI escape
+// sequences, e.g. to print it to a terminal or pipe it into less -R.
+type ansiWriter struct {
+	w      *bufio.Writer
+	colors map[jsonfmt.TokenType]string
+
+	// lineOpen is set if the current line is not empty, ended if it was
+	// ended by Flush rather than Newline.
+	lineOpen bool
+	ended    bool
+}
+
+// New creates a writer to w that colors the tokens like the viewer does
+// with colorMap. If colorMap is nil, plain text is written.
+func New(w io.Writer, colorMap map[jsonfmt.TokenType]termbox.Attribute) *ansiWriter {
+	colors := map[jsonfmt.TokenType]string{}
+	for t, attr := range colorMap {
+		if sgr := SGR(attr); sgr != "" {
+			colors[t] = sgr
+		}
+	}
+
+	return &ansiWriter{w: bufio.NewWriter(w), colors: colors}
+}
+
+func (w *ansiWriter) Write(s string, t jsonfmt.TokenType) {
+	if s == "" {
+		return
+	}
+	w.lineOpen, w.ended = true, false
+
+	sgr, ok := w.colors[t]
+	if !ok {
+		w.w.WriteString(s)
+		return
+	}
+	w.w.WriteString("\x1b[" + sgr + "m")
+	w.w.WriteString(s)
+	w.w.WriteString("\x1b[0m")
+}
+
+// Newline ends the current line, unless Flush already did.
+func (w *ansiWriter) Newline() {
+	if w.ended {
+		w.ended = false
+		return
+	}
+	w.w.WriteByte('\n')
+	w.lineOpen = false
+}
+
+// Flush ends the current line, so the output can be read line by line,
+// and writes everything buffered to the underlying writer. Formatting may
+// go on afterwards, e.g. with records appended to a stream.
+func (w *ansiWriter) Flush() error {
+	if w.lineOpen {
+		w.w.WriteByte('\n')
+		w.lineOpen, w.ended = false, true
+	}
+	return w.w.Flush()
+}
+
+// SGR returns the parameters of the ANSI escape sequence that selects the
+// color and attributes of a termbox cell, or "" for the default ones.
+func SGR(attr termbox.Attribute) string {
+	var params []string
+	if attr&termbox.AttrBold != 0 {
+		params = append(params, "1")
+	}
+	if attr&termbox.AttrUnderline != 0 {
+		params = append(params, "4")
+	}
+	if attr&termbox.AttrReverse != 0 {
+		params = append(params, "7")
+	}
+
+	// The termbox colors from black to white are numbered from 1 on, ANSI
+	// ones from 30 on.
+	if color := attr & 0xFF; color >= termbox.ColorBlack && color <= termbox.ColorWhite {
+		params = append(params, strconv.Itoa(30+int(color-termbox.ColorBlack)))
+	}
+	return strings.Join(params, ";")
+}
@@ -0,0 +1,77 @@
+package ansiwriter
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/maxzender/jv/jsonfmt"
+	"github.com/nsf/termbox-go"
+)
+
+var testColorMap = map[jsonfmt.TokenType]termbox.Attribute{
+	jsonfmt.DelimiterType: termbox.ColorDefault,
+	jsonfmt.KeyType:       termbox.ColorBlue,
+	jsonfmt.NumberType:    termbox.ColorYellow | termbox.AttrBold,
+	jsonfmt.ErrorType:     termbox.ColorRed | termbox.AttrReverse,
+}
+
+func write(w *ansiWriter) {
+	w.Write(`{`, jsonfmt.DelimiterType)
+	w.Newline()
+	w.Write(`    `, jsonfmt.WhiteSpaceType)
+	w.Write(`"test"`, jsonfmt.KeyType)
+	w.Write(`:`, jsonfmt.DelimiterType)
+	w.Write(` `, jsonfmt.WhiteSpaceType)
+	w.Write(`4`, jsonfmt.NumberType)
+	w.Newline()
+	w.Write(`}`, jsonfmt.DelimiterType)
+}
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+	writer := New(&buf, testColorMap)
+	write(writer)
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "{\n    \x1b[34m\"test\"\x1b[0m: \x1b[1;33m4\x1b[0m\n}\n"
+	if actual := buf.String(); actual != expected {
+		t.Errorf("got %q, want %q", actual, expected)
+	}
+}
+
+func TestWritePlain(t *testing.T) {
+	var buf bytes.Buffer
+	writer := New(&buf, nil)
+	write(writer)
+	writer.Flush()
+
+	// Formatting goes on after a flush without an empty line.
+	writer.Newline()
+	writer.Write(`[]`, jsonfmt.DelimiterType)
+	writer.Flush()
+
+	expected := "{\n    \"test\": 4\n}\n[]\n"
+	if actual := buf.String(); actual != expected {
+		t.Errorf("got %q, want %q", actual, expected)
+	}
+}
+
+func TestSGR(t *testing.T) {
+	examples := []struct {
+		attr     termbox.Attribute
+		expected string
+	}{
+		{termbox.ColorDefault, ""},
+		{termbox.ColorBlack, "30"},
+		{termbox.ColorWhite, "37"},
+		{termbox.ColorRed | termbox.AttrReverse, "7;31"},
+		{termbox.ColorCyan | termbox.AttrBold | termbox.AttrUnderline, "1;4;36"},
+	}
+	for _, tt := range examples {
+		if actual := SGR(tt.attr); actual != tt.expected {
+			t.Errorf("SGR(%v): %q, want %q", tt.attr, actual, tt.expected)
+		}
+	}
+}
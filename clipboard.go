@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/maxzender/jv/jsonast"
+	"github.com/maxzender/jv/jsonfmt"
+)
+
+// yankMenu lists what can be copied, shown after pressing y.
+const yankMenu = `copy: . jq path  / JSON Pointer  j JavaScript  [ Python  v value  V pretty value  (Esc: cancel)`
+
+// pathBase is the variable JavaScript and Python paths start with.
+const pathBase = "data"
+
+// yank copies what key selects of the node under the cursor to the
+// clipboard and describes the result in the status bar.
+func (v *viewer) yank(key rune) {
+	n := v.term.CurrentNode()
+	if n == nil {
+		return
+	}
+
+	var text, what string
+	switch key {
+	case '.':
+		text, what = n.Path(), "jq path"
+	case '/':
+		text, what = n.Pointer(), "JSON Pointer"
+	case 'j':
+		text, what = n.JSPath(pathBase), "JavaScript path"
+	case '[':
+		text, what = n.PythonPath(pathBase), "Python path"
+	case 'v':
+		text, what = jsonast.Compact(n), "value"
+	case 'V':
+		text, what = v.pretty(n), "pretty value"
+	default:
+		return
+	}
+
+	if err := copyToClipboard(os.Stdout, text); err != nil {
+		v.notice = fmt.Sprintf("copy failed: %v", err)
+		return
+	}
+	if key == 'v' || key == 'V' {
+		v.notice = fmt.Sprintf("copied %s (%s)", what, formatSize(int64(len(text))))
+	} else {
+		v.notice = fmt.Sprintf("copied %s: %s", what, text)
+	}
+}
+
+// pretty returns n as JSON like the value copied with v, indented as
+// shown.
+func (v *viewer) pretty(n *jsonast.Node) string {
+	indent := "\t"
+	if !v.opts.tabs {
+		width := v.opts.indent
+		if width == 0 {
+			width = jsonfmt.IndentationDepth
+		}
+		indent = strings.Repeat(" ", width)
+	}
+	return jsonast.Indent(n, indent)
+}
+
+// copyToClipboard puts text into the clipboard of the terminal written to
+// by w with the OSC 52 escape sequence, which works over SSH as well.
+// Within tmux, the sequence is passed through to the outer terminal.
+func copyToClipboard(w io.Writer, text string) error {
+	seq := "\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a"
+	if os.Getenv("TMUX") != "" {
+		seq = "\x1bPtmux;" + strings.Replace(seq, "\x1b", "\x1b\x1b", -1) + "\x1b\\"
+	}
+	_, err := io.WriteString(w, seq)
+	return err
+}
@@ -1,16 +1,24 @@
 package colorwriter
 
 import (
+	"github.com/maxzender/jv/jsonast"
 	"github.com/maxzender/jv/jsonfmt"
 	"github.com/maxzender/jv/jsontree"
 	"github.com/nsf/termbox-go"
 )
 
 type colorWriter struct {
-	Lines    []jsontree.Line
+	Lines []jsontree.Line
+	Nodes []*jsonast.Node
+
+	// SkipText only records the node of every line and leaves Lines empty,
+	// for trees whose lines are rendered on demand.
+	SkipText bool
+
 	colorMap map[jsonfmt.TokenType]termbox.Attribute
 	line     int
 	bgColor  termbox.Attribute
+	open     []*jsonast.Node
 }
 
 func New(colorMap map[jsonfmt.TokenType]termbox.Attribute, bgColor termbox.Attribute) *colorWriter {
@@ -20,17 +28,59 @@ func New(colorMap map[jsonfmt.TokenType]termbox.Attribute, bgColor termbox.Attri
 	}
 
 	writer.Lines = append(writer.Lines, jsontree.Line{})
+	writer.Nodes = append(writer.Nodes, nil)
 
 	return writer
 }
 
 func (w *colorWriter) Write(s string, t jsonfmt.TokenType) {
+	if w.SkipText {
+		return
+	}
 	for _, c := range s {
-		w.Lines[w.line] = append(w.Lines[w.line], jsontree.Char{c, w.colorMap[t]})
+		if c == '\t' {
+			// Tabs are expanded, as every cell of the screen holds one
+			// character.
+			for n := jsonfmt.TabWidth - len(w.Lines[w.line])%jsonfmt.TabWidth; n > 0; n-- {
+				w.Lines[w.line] = append(w.Lines[w.line], jsontree.Char{Val: ' ', Color: w.colorMap[t]})
+			}
+			continue
+		}
+		w.Lines[w.line] = append(w.Lines[w.line], jsontree.Char{Val: c, Color: w.colorMap[t]})
 	}
 }
 
+// Newline starts a new line, which belongs to the innermost open container
+// until a member or element is written on it.
 func (w *colorWriter) Newline() {
-	w.Lines = append(w.Lines, jsontree.Line{})
+	var node *jsonast.Node
+	if len(w.open) > 0 {
+		node = w.open[len(w.open)-1]
+	}
+
+	if !w.SkipText {
+		w.Lines = append(w.Lines, jsontree.Line{})
+	}
+	w.Nodes = append(w.Nodes, node)
 	w.line++
 }
+
+func (w *colorWriter) BeginObject(n *jsonast.Node) {
+	w.open = append(w.open, n)
+}
+
+func (w *colorWriter) BeginArray(n *jsonast.Node) {
+	w.open = append(w.open, n)
+}
+
+func (w *colorWriter) EndContainer(n *jsonast.Node) {
+	w.open = w.open[:len(w.open)-1]
+}
+
+func (w *colorWriter) Key(n *jsonast.Node) {
+	w.Nodes[w.line] = n
+}
+
+func (w *colorWriter) Value(n *jsonast.Node) {
+	w.Nodes[w.line] = n
+}
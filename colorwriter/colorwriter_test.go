@@ -4,6 +4,7 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/maxzender/jv/jsonast"
 	"github.com/maxzender/jv/jsonfmt"
 	"github.com/maxzender/jv/jsontree"
 	"github.com/nsf/termbox-go"
@@ -57,3 +58,68 @@ func TestWrite(t *testing.T) {
 		t.Errorf("Expected:\n%v but received:\n%v", expected, actual)
 	}
 }
+
+func TestNodes(t *testing.T) {
+	input := `{"a{b": ["]", {"c": "}"}], "d": {}}`
+	root, err := jsonast.Parse([]byte(input))
+	if err != nil {
+		t.Fatalf("Parse(%v): %v", input, err)
+	}
+
+	writer := New(testColorMap, defaultColor)
+	jsonfmt.New(root, writer, jsonfmt.Options{}).Format()
+
+	var actual []string
+	for _, n := range writer.Nodes {
+		actual = append(actual, n.Path())
+	}
+
+	expected := []string{
+		".",
+		`.["a{b"]`,
+		`.["a{b"][0]`,
+		`.["a{b"][1]`,
+		`.["a{b"][1].c`,
+		`.["a{b"][1]`,
+		`.["a{b"]`,
+		".d",
+		".",
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected:\n%v but received:\n%v", expected, actual)
+	}
+
+	// Lines of inlined containers belong to the container.
+	writer = New(testColorMap, defaultColor)
+	jsonfmt.New(root, writer, jsonfmt.Options{InlineWidth: 40}).Format()
+
+	actual = nil
+	for _, n := range writer.Nodes {
+		actual = append(actual, n.Path())
+	}
+
+	expected = []string{
+		".",
+		`.["a{b"]`,
+		`.["a{b"][0]`,
+		`.["a{b"][1]`,
+		`.["a{b"]`,
+		".d",
+		".",
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected:\n%v but received:\n%v", expected, actual)
+	}
+}
+
+func TestWriteTabs(t *testing.T) {
+	writer := New(testColorMap, defaultColor)
+	writer.Write("\t\t", jsonfmt.WhiteSpaceType)
+	writer.Write("1", jsonfmt.NumberType)
+
+	if actual := len(writer.Lines[0]); actual != 2*jsonfmt.TabWidth+1 {
+		t.Errorf("Expected %d characters but received %d", 2*jsonfmt.TabWidth+1, actual)
+	}
+}
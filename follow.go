@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"time"
+)
+
+// followInterval is how often a followed file is checked for growth.
+const followInterval = 250 * time.Millisecond
+
+// follower watches a growing file of JSON Lines, like tail -f, and sends
+// the complete lines appended to it on lines, to be added to the stream
+// with jsonast.Append. If the file shrinks, e.g. because it was truncated,
+// it is read from the start again.
+type follower struct {
+	file    *os.File
+	offset  int64
+	pending []byte
+	lines   chan []byte
+}
+
+// follow starts watching file from offset on.
+func follow(file *os.File, offset int64) *follower {
+	f := &follower{file: file, offset: offset, lines: make(chan []byte)}
+	go f.run()
+	return f
+}
+
+func (f *follower) run() {
+	for range time.Tick(followInterval) {
+		info, err := f.file.Stat()
+		if err != nil {
+			continue
+		}
+		if info.Size() < f.offset {
+			f.offset, f.pending = 0, nil
+		}
+		if info.Size() == f.offset {
+			continue
+		}
+
+		buf := make([]byte, info.Size()-f.offset)
+		n, _ := f.file.ReadAt(buf, f.offset)
+		f.offset += int64(n)
+		f.pending = append(f.pending, buf[:n]...)
+
+		// Only complete lines are sent, the rest may still be written.
+		end := bytes.LastIndexByte(f.pending, '\n') + 1
+		if end == 0 {
+			continue
+		}
+		lines := f.pending[:end]
+		f.pending = append([]byte(nil), f.pending[end:]...)
+		f.lines <- lines
+	}
+}
+
+// completeLines cuts content after its last newline, so a record that is
+// still being written is left to the follower.
+func completeLines(content []byte) []byte {
+	return content[:bytes.LastIndexByte(content, '\n')+1]
+}
@@ -0,0 +1,124 @@
+package jsonast
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// binaryDecoder holds the state shared by the MessagePack and CBOR
+// decoders. Positions in binary input only have an Offset.
+type binaryDecoder struct {
+	data []byte
+	off  int
+}
+
+// decodeAll decodes values with decode until the input is exhausted. A
+// single value is returned as is, several as a Stream node.
+func (d *binaryDecoder) decodeAll(decode func(parent *Node, index int) (*Node, error)) (*Node, error) {
+	stream := &Node{Type: Stream}
+	for d.off < len(d.data) || len(stream.Children) == 0 {
+		n, err := decode(stream, len(stream.Children))
+		if err != nil {
+			return nil, err
+		}
+		stream.Children = append(stream.Children, n)
+	}
+	stream.End = d.pos()
+
+	if len(stream.Children) == 1 {
+		root := stream.Children[0]
+		root.Parent = nil
+		return root, nil
+	}
+	return stream, nil
+}
+
+func (d *binaryDecoder) readByte() (byte, error) {
+	if d.off >= len(d.data) {
+		return 0, d.errorf("unexpected end of input")
+	}
+	d.off++
+	return d.data[d.off-1], nil
+}
+
+func (d *binaryDecoder) read(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.off) {
+		return nil, d.errorf("unexpected end of input, %d more bytes expected", n)
+	}
+	d.off += int(n)
+	return d.data[d.off-int(n) : d.off], nil
+}
+
+// readUint reads a big-endian unsigned integer of size bytes.
+func (d *binaryDecoder) readUint(size int) (uint64, error) {
+	b, err := d.read(uint64(size))
+	if err != nil {
+		return 0, err
+	}
+
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// checkCount makes sure count items, each at least one byte long, can still
+// follow, so corrupt lengths do not lead to huge allocations.
+func (d *binaryDecoder) checkCount(count uint64) error {
+	if count > uint64(len(d.data)-d.off) {
+		return d.errorf("length %d exceeds the remaining input", count)
+	}
+	return nil
+}
+
+func (d *binaryDecoder) pos() Position {
+	return Position{Offset: d.off}
+}
+
+func (d *binaryDecoder) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Msg: fmt.Sprintf(format, args...), Pos: d.pos()}
+}
+
+// setFloat makes n a Number with the shortest literal for f, using the
+// JSON5 spelling for infinities and NaN.
+func setFloat(n *Node, f float64, bits int) {
+	n.Type = Number
+	switch {
+	case math.IsInf(f, 1):
+		n.Literal = "Infinity"
+	case math.IsInf(f, -1):
+		n.Literal = "-Infinity"
+	case math.IsNaN(f):
+		n.Literal = "NaN"
+	default:
+		n.Literal = strconv.FormatFloat(f, 'g', -1, bits)
+	}
+}
+
+func setBytes(n *Node, b []byte) {
+	n.Type, n.Value = Bytes, string(b)
+}
+
+// setTime makes n a DateTime with t in RFC 3339 notation.
+func setTime(n *Node, t time.Time) {
+	n.Type, n.Literal = DateTime, t.UTC().Format(time.RFC3339Nano)
+}
+
+// keyText returns the text used as key for a map key that is no string.
+func keyText(key *Node) string {
+	switch key.Type {
+	case String:
+		return key.Value
+	case Bytes:
+		return fmt.Sprintf("%x", key.Value)
+	}
+	return key.Literal
+}
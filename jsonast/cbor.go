@@ -0,0 +1,244 @@
+package jsonast
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+	"time"
+)
+
+// cborMagic is the self-described CBOR tag, which can prefix CBOR data to
+// identify it.
+const cborMagic = "\xd9\xd9\xf7"
+
+// ParseCBOR decodes CBOR data. Several concatenated data items are returned
+// as a Stream node. Byte strings become Bytes nodes, date/time tags DateTime
+// nodes and bignums numbers. Other tags are ignored.
+func ParseCBOR(data []byte) (*Node, error) {
+	d := &cborDecoder{binaryDecoder{data: data}}
+	return d.decodeAll(d.decode)
+}
+
+// IsCBOR reports whether data starts with the self-described CBOR tag.
+func IsCBOR(data []byte) bool {
+	return len(data) >= len(cborMagic) && string(data[:len(cborMagic)]) == cborMagic
+}
+
+type cborDecoder struct {
+	binaryDecoder
+}
+
+// errBreak signals the break code ending an indefinite length item.
+var errBreak = errors.New("break")
+
+func (d *cborDecoder) decode(parent *Node, index int) (*Node, error) {
+	n, err := d.decodeOrBreak(parent, index)
+	if err == errBreak {
+		d.off--
+		return nil, d.errorf("unexpected break code")
+	}
+	return n, err
+}
+
+// decodeOrBreak decodes a data item or returns errBreak if the break code
+// ending an indefinite length array or map follows.
+func (d *cborDecoder) decodeOrBreak(parent *Node, index int) (*Node, error) {
+	n := &Node{Parent: parent, Index: index, Start: d.pos()}
+	major, info, arg, err := d.readHead()
+	if err != nil {
+		return nil, err
+	}
+	indefinite := info == 31
+
+	switch major {
+	case 0:
+		n.Type, n.Literal = Number, strconv.FormatUint(arg, 10)
+	case 1:
+		v := new(big.Int).SetUint64(arg)
+		n.Type, n.Literal = Number, v.Sub(big.NewInt(-1), v).String()
+	case 2, 3:
+		var b []byte
+		if b, err = d.decodeChunks(major, indefinite, arg); err != nil {
+			return nil, err
+		}
+		if major == 2 {
+			setBytes(n, b)
+		} else {
+			setString(n, string(b))
+		}
+	case 4:
+		n.Type = Array
+		if err := d.checkCount(arg); err != nil {
+			return nil, err
+		}
+		for i := uint64(0); indefinite || i < arg; i++ {
+			child, err := d.decodeOrBreak(n, int(i))
+			if err == errBreak && indefinite {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			n.Children = append(n.Children, child)
+		}
+	case 5:
+		n.Type = Object
+		if err := d.checkCount(arg); err != nil {
+			return nil, err
+		}
+		for i := uint64(0); indefinite || i < arg; i++ {
+			key, err := d.decodeOrBreak(n, int(i))
+			if err == errBreak && indefinite {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if key.IsContainer() {
+				return nil, &SyntaxError{Msg: "map keys must be scalars", Pos: key.Start}
+			}
+
+			child, err := d.decode(n, int(i))
+			if err != nil {
+				return nil, err
+			}
+			child.Key = keyText(key)
+			child.KeyLiteral = Quote(child.Key)
+			n.Children = append(n.Children, child)
+		}
+	case 6:
+		return d.decodeTag(n, arg)
+	case 7:
+		if err := d.decodeSimple(n, info, arg); err != nil {
+			return nil, err
+		}
+	}
+
+	n.End = d.pos()
+	return n, nil
+}
+
+// readHead reads the initial byte of a data item and its argument. info is
+// 31 for indefinite lengths and the break code.
+func (d *cborDecoder) readHead() (major, info byte, arg uint64, err error) {
+	start := d.off
+	c, err := d.readByte()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info = c>>5, c&0x1f
+
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		arg, err = d.readUint(1 << (info - 24))
+	case info == 31 && (major >= 2 && major <= 5 || major == 7):
+		if major == 7 {
+			return 0, 0, 0, errBreak
+		}
+	default:
+		d.off = start
+		err = d.errorf("invalid CBOR initial byte 0x%02x", c)
+	}
+	return major, info, arg, err
+}
+
+// decodeChunks reads a byte or text string, which is made of chunks of the
+// same major type if its length is indefinite.
+func (d *cborDecoder) decodeChunks(major byte, indefinite bool, size uint64) ([]byte, error) {
+	if !indefinite {
+		return d.read(size)
+	}
+
+	var b []byte
+	for {
+		start := d.off
+		chunkMajor, info, size, err := d.readHead()
+		if err == errBreak {
+			return b, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if chunkMajor != major || info == 31 {
+			d.off = start
+			return nil, d.errorf("invalid chunk in indefinite length string")
+		}
+
+		chunk, err := d.read(size)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, chunk...)
+	}
+}
+
+func (d *cborDecoder) decodeTag(n *Node, tag uint64) (*Node, error) {
+	content, err := d.decode(n.Parent, n.Index)
+	if err != nil {
+		return nil, err
+	}
+	content.Start = n.Start
+
+	switch {
+	case tag == 0 && content.Type == String:
+		content.Type, content.Literal, content.Value = DateTime, content.Value, ""
+	case tag == 1 && content.Type == Number:
+		if f, err := strconv.ParseFloat(content.Literal, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+			sec, frac := math.Modf(f)
+			setTime(content, time.Unix(int64(sec), int64(frac*1e9)))
+		}
+	case (tag == 2 || tag == 3) && content.Type == Bytes:
+		v := new(big.Int).SetBytes([]byte(content.Value))
+		if tag == 3 {
+			v.Sub(big.NewInt(-1), v)
+		}
+		content.Type, content.Literal, content.Value = Number, v.String(), ""
+	}
+
+	return content, nil
+}
+
+func (d *cborDecoder) decodeSimple(n *Node, info byte, arg uint64) error {
+	switch info {
+	case 20:
+		n.Type, n.Literal = Bool, "false"
+	case 21:
+		n.Type, n.Literal = Bool, "true"
+	case 22, 23:
+		n.Type, n.Literal = Null, "null"
+	case 25:
+		setFloat(n, halfFloat(uint16(arg)), 32)
+	case 26:
+		setFloat(n, float64(math.Float32frombits(uint32(arg))), 32)
+	case 27:
+		setFloat(n, math.Float64frombits(arg), 64)
+	default:
+		return &SyntaxError{Msg: "unsupported simple value " + strconv.FormatUint(arg, 10), Pos: n.Start}
+	}
+	return nil
+}
+
+// halfFloat converts an IEEE 754 half-precision float.
+func halfFloat(h uint16) float64 {
+	exp, mant := int(h>>10&0x1f), float64(h&0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		f = math.Inf(1)
+		if mant != 0 {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
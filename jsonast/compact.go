@@ -0,0 +1,97 @@
+package jsonast
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// Compact returns n as JSON without any whitespace. Strings are quoted
+// anew and numbers written in JSON notation. Dates and times, binary data
+// and the infinities and NaN, which JSON has no notation for, become
+// strings, binary data base64 encoded. The records of a stream are written
+// on lines of their own.
+func Compact(n *Node) string {
+	return Indent(n, "")
+}
+
+// Indent returns n as JSON like Compact, but with every member and element
+// on a line of its own, indented by indent per nesting level.
+func Indent(n *Node, indent string) string {
+	var b strings.Builder
+	writeJSON(&b, n, indent, 0)
+	return b.String()
+}
+
+func writeJSON(b *strings.Builder, n *Node, indent string, depth int) {
+	newline := func(depth int) {
+		if indent != "" {
+			b.WriteByte('\n')
+			b.WriteString(strings.Repeat(indent, depth))
+		}
+	}
+
+	switch n.Type {
+	case Object:
+		b.WriteByte('{')
+		n.Range(0, func(member *Node) bool {
+			if member.Index > 0 {
+				b.WriteByte(',')
+			}
+			newline(depth + 1)
+			b.WriteString(Quote(member.Key))
+			b.WriteByte(':')
+			if indent != "" {
+				b.WriteByte(' ')
+			}
+			writeJSON(b, member, indent, depth+1)
+			return true
+		})
+		if n.Len() > 0 {
+			newline(depth)
+		}
+		b.WriteByte('}')
+	case Array:
+		b.WriteByte('[')
+		n.Range(0, func(element *Node) bool {
+			if element.Index > 0 {
+				b.WriteByte(',')
+			}
+			newline(depth + 1)
+			writeJSON(b, element, indent, depth+1)
+			return true
+		})
+		if n.Len() > 0 {
+			newline(depth)
+		}
+		b.WriteByte(']')
+	case Stream:
+		n.Range(0, func(record *Node) bool {
+			if record.Index > 0 {
+				b.WriteByte('\n')
+			}
+			writeJSON(b, record, indent, depth)
+			return true
+		})
+	case String:
+		b.WriteString(Quote(n.Value))
+	case Number:
+		b.WriteString(compactNumber(n.Literal))
+	case DateTime:
+		b.WriteString(Quote(n.Literal))
+	case Bytes:
+		b.WriteString(Quote(base64.StdEncoding.EncodeToString([]byte(n.Value))))
+	default:
+		b.WriteString(n.Literal)
+	}
+}
+
+// compactNumber returns the number literal in JSON notation, or quoted for the
+// infinities and NaN.
+func compactNumber(literal string) string {
+	switch s := numberLiteral(literal); s {
+	case "Infinity", "-Infinity", "NaN":
+		return Quote(s)
+	default:
+		return s
+	}
+}
@@ -0,0 +1,107 @@
+package jsonast
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+)
+
+var jsonNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+// ParseCSV parses comma separated values into an array with one object per
+// row, keyed by the names in the header row. comma is the field delimiter,
+// e.g. '\t' for TSV. Fields without a name in the header are keyed by their
+// column number. If inferTypes is set, fields that are JSON numbers, true,
+// false or null, as well as empty fields, are converted accordingly;
+// otherwise every field is a string.
+func ParseCSV(data []byte, comma rune, inferTypes bool) (*Node, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	lines := lineOffsets(data)
+	position := func(line, column int) Position {
+		line = min(line, len(lines))
+		offset := min(lines[line-1]+column-1, len(data))
+		return Position{
+			Offset: offset,
+			Line:   line,
+			Column: utf8.RuneCount(data[lines[line-1]:offset]) + 1,
+		}
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = comma
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = comma == '\t'
+
+	root := &Node{Type: Array, Start: Position{Line: 1, Column: 1}}
+	var header []string
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err, ok := err.(*csv.ParseError); ok {
+			return nil, &SyntaxError{Msg: err.Err.Error(), Pos: position(err.Line, err.Column)}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if header == nil {
+			header = record
+			continue
+		}
+
+		line, column := r.FieldPos(0)
+		row := &Node{Type: Object, Parent: root, Index: len(root.Children), Start: position(line, column)}
+		for i, field := range record {
+			key := strconv.Itoa(i + 1)
+			if i < len(header) && header[i] != "" {
+				key = header[i]
+			}
+
+			line, column := r.FieldPos(i)
+			n := &Node{
+				Parent:     row,
+				Index:      i,
+				Key:        key,
+				KeyLiteral: Quote(key),
+				Start:      position(line, column),
+			}
+			if inferTypes {
+				inferType(n, field)
+			} else {
+				setString(n, field)
+			}
+			row.Children = append(row.Children, n)
+		}
+		root.Children = append(root.Children, row)
+	}
+
+	end := position(len(lines), len(data)-lines[len(lines)-1]+1)
+	root.End = end
+	for i, row := range root.Children {
+		row.End = end
+		if i+1 < len(root.Children) {
+			row.End = root.Children[i+1].Start
+		}
+	}
+
+	return root, nil
+}
+
+// inferType sets the type of a CSV field from its text.
+func inferType(n *Node, field string) {
+	switch {
+	case field == "" || field == "null":
+		n.Type, n.Literal = Null, "null"
+	case field == "true" || field == "false":
+		n.Type, n.Literal = Bool, field
+	case jsonNumber.MatchString(field):
+		n.Type, n.Literal = Number, field
+	default:
+		setString(n, field)
+	}
+}
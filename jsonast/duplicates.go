@@ -0,0 +1,66 @@
+package jsonast
+
+// FindDuplicates marks the object members whose key is used by another
+// member of the same object and returns the objects containing them, in the
+// order they appear in the document.
+func FindDuplicates(root *Node) []*Node {
+	if x, s, ok := root.indexed(); ok {
+		return x.findDuplicates(s)
+	}
+
+	var objects []*Node
+	seen := map[string]*Node{}
+
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if n.Type == Object && len(n.Children) > 1 && markDuplicates(n.Children, seen) {
+			objects = append(objects, n)
+		}
+
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+	walk(root)
+
+	return objects
+}
+
+// smallObject is the number of members up to which keys are compared with
+// each other instead of looking them up, which is faster for few members.
+const smallObject = 16
+
+// markDuplicates marks the members with the same key as another one and
+// reports whether there are any. seen is an empty map to use for lookups
+// and is left empty.
+func markDuplicates(members []*Node, seen map[string]*Node) bool {
+	found := false
+	if len(members) <= smallObject {
+		for i, member := range members {
+			for _, other := range members[:i] {
+				if member.Key == other.Key && member.KeyLiteral != "" && other.KeyLiteral != "" {
+					member.Duplicate, other.Duplicate = true, true
+					found = true
+				}
+			}
+		}
+		return found
+	}
+
+	for _, member := range members {
+		if member.KeyLiteral == "" {
+			// Recovered members may lack a key.
+			continue
+		}
+		if first, ok := seen[member.Key]; ok {
+			first.Duplicate, member.Duplicate = true, true
+			found = true
+		} else {
+			seen[member.Key] = member
+		}
+	}
+	for key := range seen {
+		delete(seen, key)
+	}
+	return found
+}
@@ -0,0 +1,75 @@
+package jsonast
+
+import (
+	"fmt"
+	"strings"
+)
+
+// excerptContext is the number of lines shown before and after the line of
+// the position in an excerpt.
+const excerptContext = 2
+
+// excerptWidth is the number of runes shown of each line in an excerpt.
+const excerptWidth = 72
+
+// Excerpt returns the source lines around pos, numbered and with a caret
+// pointing at the column, e.g. to show where a syntax error occurred. Long
+// lines are cut to a window around the column. It returns "" for positions
+// in binary input.
+func Excerpt(data []byte, pos Position) string {
+	if pos.Line == 0 {
+		return ""
+	}
+
+	lines := lineOffsets(data)
+	first := max(1, pos.Line-excerptContext)
+	last := min(len(lines), pos.Line+excerptContext)
+	if last > pos.Line && lines[last-1] == len(data) {
+		// Nothing follows the final newline.
+		last--
+	}
+	width := len(fmt.Sprint(last))
+
+	start := 0
+	if pos.Column-1 > excerptWidth/2 {
+		start = pos.Column - 1 - excerptWidth/2
+	}
+
+	var b strings.Builder
+	for ln := first; ln <= last; ln++ {
+		text := []rune(strings.TrimRight(restOfLine(data[lines[ln-1]:]), "\r"))
+		cut := ""
+		if start > 0 {
+			cut = "…"
+		}
+		if start < len(text) {
+			text = text[start:]
+		} else {
+			text = nil
+		}
+		if len(text) > excerptWidth {
+			text = append(text[:excerptWidth], '…')
+		}
+
+		line := strings.Replace(cut+string(text), "\t", " ", -1)
+		fmt.Fprintf(&b, "%*d | %s\n", width, ln, line)
+
+		if ln == pos.Line {
+			caret := strings.Repeat(" ", pos.Column-1-start+len([]rune(cut)))
+			fmt.Fprintf(&b, "%*s | %s^\n", width, "", caret)
+		}
+	}
+
+	return b.String()
+}
+
+// lineOffsets returns the offset at which each line of data starts.
+func lineOffsets(data []byte) []int {
+	offsets := []int{0}
+	for i, c := range data {
+		if c == '\n' {
+			offsets = append(offsets, i+1)
+		}
+	}
+	return offsets
+}
@@ -0,0 +1,549 @@
+package jsonast
+
+import "sort"
+
+// markInterval is the number of children of a container between the ones
+// whose positions an index keeps, so any child is found by skipping at most
+// that many others.
+const markInterval = 32
+
+// index locates the values of a JSON document or stream in its source, so
+// huge documents can be shown without decoding them up front. It holds a
+// span for every container, in the order the containers start, and for
+// containers with many children the positions of every markInterval-th
+// child. Nodes are decoded from the source when they are used.
+type index struct {
+	data  []byte
+	spans []span
+	marks map[int32][]mark
+
+	// stream is set if span 0 is the root of a stream, whose records that
+	// could not be parsed invalid holds by offset.
+	stream  bool
+	invalid map[int]*Node
+
+	// duplicates holds the offsets of the members FindDuplicates marked.
+	duplicates map[int]bool
+
+	// root is the node of span 0. While the index is built, line is the
+	// number of lines so far, open holds the containers not closed yet and
+	// done is the line and offset after the last complete child of the
+	// root. pos is where building stopped, so a stream can be continued.
+	root           *Node
+	line           int
+	open           []int32
+	doneLine, done int
+	pos            Position
+}
+
+// span is a container in the source. Lines are counted as if every value
+// was written on a line of its own: the container takes lines lines from
+// line on, the last closing it unless it is empty. count is the number of
+// its children and next the span after its descendants.
+type span struct {
+	start, end  int
+	line, lines int
+	parent      int32
+	next        int32
+	count       int32
+	depth       int32
+}
+
+// mark is the position of a child of a container: the offset it starts at,
+// including the key of a member, the line it starts on and the first span
+// at or after it.
+type mark struct {
+	offset int
+	line   int
+	span   int32
+}
+
+// entry is a child of a container as found by scan: its index, the offsets
+// of the child and of its value, which differ for members, and its span if
+// it is a container, -1 otherwise.
+type entry struct {
+	index  int
+	offset int
+	value  int
+	span   int32
+}
+
+// IndexJSON checks a single JSON value like Parse without decoding it. The
+// nodes of the returned value are decoded from data when they are used, see
+// Indexed, so data must not be changed afterwards. Only the containers are
+// indexed, a scalar is parsed right away. progress may be nil.
+func IndexJSON(data []byte, progress Progress) (*Node, error) {
+	x := &index{data: data, marks: map[int32][]mark{}}
+	p := newParser(data)
+	p.progress = progress
+
+	p.skipWhitespace()
+	if c, _ := p.peek(); c != '{' && c != '[' {
+		return ParseProgress(data, false, progress)
+	}
+	if err := p.indexValue(x, -1); err != nil {
+		return nil, err
+	}
+	p.skipWhitespace()
+	if c, ok := p.peek(); ok {
+		return nil, p.errorf("invalid character %s after top-level value", quoteChar(c))
+	}
+	return x.root, nil
+}
+
+// IndexStream checks a sequence of JSON values like ParseStream without
+// decoding them, see IndexJSON. It only fails if it is canceled.
+func IndexStream(data []byte, progress Progress) (*Node, error) {
+	x := &index{
+		data:    data,
+		spans:   []span{{parent: -1, depth: -1}},
+		marks:   map[int32][]mark{},
+		stream:  true,
+		invalid: map[int]*Node{},
+		open:    []int32{0},
+	}
+	x.root = &Node{Type: Stream, index: x}
+	p := newParser(data)
+	p.progress, p.top = progress, x.root
+	if err := p.indexRecords(x); err != nil {
+		return nil, err
+	}
+	return x.root, nil
+}
+
+// Append adds the records in data, which continues the source of stream, to
+// stream and returns them. Streams read with IndexStream are indexed further,
+// others get the records of ParseStream.
+func Append(stream *Node, data []byte) []*Node {
+	x := stream.index
+	if x == nil {
+		records := ParseStream(data).Children
+		for _, n := range records {
+			n.Parent, n.Index = stream, len(stream.Children)
+			stream.Children = append(stream.Children, n)
+		}
+		return records
+	}
+
+	count := stream.Len()
+	x.data = append(x.data, data...)
+	p := &parser{data: x.data, pos: x.pos}
+	p.indexRecords(x)
+
+	var records []*Node
+	stream.Range(count, func(n *Node) bool {
+		records = append(records, n)
+		return true
+	})
+	return records
+}
+
+// indexRecords adds the records from the current position on to the stream
+// root of x. Records that fail to parse are kept like by ParseStream.
+func (p *parser) indexRecords(x *index) error {
+	for {
+		p.skipWhitespace()
+		if _, ok := p.peek(); !ok {
+			break
+		}
+
+		x.addMark(p, 0)
+		start, spans, line := p.pos, len(x.spans), x.line
+		err := p.indexValue(x, 0)
+		if err == ErrCanceled {
+			return err
+		}
+		if err != nil {
+			x.truncate(spans)
+			x.line = line + 1
+			x.invalid[start.Offset] = p.invalidRecord(start, err)
+		}
+		x.spans[0].count++
+		x.doneLine, x.done = x.line, p.pos.Offset
+	}
+
+	root := &x.spans[0]
+	root.end, root.lines, root.next = p.pos.Offset, x.line, int32(len(x.spans))
+	x.root.End = Position{Offset: p.pos.Offset}
+	x.pos = p.pos
+	return nil
+}
+
+// indexValue checks the value at the current position like parseValue and
+// adds the containers in it to x, parent being the span of its container.
+func (p *parser) indexValue(x *index, parent int32) error {
+	c, ok := p.peek()
+	if !ok {
+		return p.errorf("unexpected end of JSON input")
+	}
+
+	if p.progress != nil {
+		if err := p.reportProgress(); err != nil {
+			return err
+		}
+	}
+
+	x.line++
+	switch {
+	case c == '{' || c == '[':
+		return p.indexContainer(x, parent)
+	case c == '"':
+		return p.skipString()
+	case c == '-' || isDigit(c):
+		return p.skipNumber()
+	case c == 't':
+		_, err := p.parseKeyword("true")
+		return err
+	case c == 'f':
+		_, err := p.parseKeyword("false")
+		return err
+	case c == 'n':
+		_, err := p.parseKeyword("null")
+		return err
+	}
+	return p.errorf("invalid character %s looking for beginning of value", quoteChar(c))
+}
+
+// indexContainer checks the object or array at the current position like
+// parseObject and parseArray and adds a span for it to x.
+func (p *parser) indexContainer(x *index, parent int32) error {
+	id := int32(len(x.spans))
+	s := span{start: p.pos.Offset, line: x.line - 1, parent: parent}
+	if parent >= 0 {
+		s.depth = x.spans[parent].depth + 1
+	}
+	x.spans = append(x.spans, s)
+	x.open = append(x.open, id)
+
+	object := p.data[p.pos.Offset] == '{'
+	closing, context := byte(']'), "after array element"
+	if object {
+		closing, context = '}', "after object key:value pair"
+	}
+	if id == 0 {
+		x.root = &Node{Type: Array, index: x, Start: Position{Offset: s.start}}
+		if object {
+			x.root.Type = Object
+		}
+		p.top = x.root
+	}
+
+	p.advance()
+	p.skipWhitespace()
+	if c, ok := p.peek(); !ok || c != closing {
+		for {
+			x.addMark(p, id)
+			if object {
+				c, ok := p.peek()
+				switch {
+				case !ok:
+					return p.errorf("unexpected end of JSON input")
+				case c != '"':
+					return p.errorf("invalid character %s looking for beginning of object key string", quoteChar(c))
+				}
+				if err := p.skipString(); err != nil {
+					return err
+				}
+				p.skipWhitespace()
+				if err := p.expect(':', "after object key"); err != nil {
+					return err
+				}
+				p.skipWhitespace()
+			}
+			if err := p.indexValue(x, id); err != nil {
+				return err
+			}
+			x.spans[id].count++
+			if id == 0 {
+				x.doneLine, x.done = x.line, p.pos.Offset
+			}
+
+			p.skipWhitespace()
+			c, ok := p.peek()
+			if !ok {
+				return p.errorf("unexpected end of JSON input")
+			}
+			if c == closing {
+				break
+			}
+			if c != ',' {
+				return p.errorf("invalid character %s %s", quoteChar(c), context)
+			}
+			p.advance()
+			p.skipWhitespace()
+		}
+		x.line++
+	}
+	p.advance()
+
+	x.open = x.open[:len(x.open)-1]
+	sp := &x.spans[id]
+	sp.end, sp.lines, sp.next = p.pos.Offset, x.line-sp.line, int32(len(x.spans))
+	if id == 0 {
+		x.root.End = Position{Offset: sp.end}
+	}
+	return nil
+}
+
+// addMark keeps the position of the child of s starting at the current
+// position if it is one of every markInterval.
+func (x *index) addMark(p *parser, s int32) {
+	if count := x.spans[s].count; count > 0 && count%markInterval == 0 {
+		x.marks[s] = append(x.marks[s], mark{p.pos.Offset, x.line, int32(len(x.spans))})
+	}
+}
+
+// truncate drops the spans from the given one on, which belong to a record
+// that failed to parse.
+func (x *index) truncate(spans int) {
+	for s := spans; s < len(x.spans); s++ {
+		delete(x.marks, int32(s))
+	}
+	x.spans = x.spans[:spans]
+}
+
+// partial returns a copy of the root of x holding its complete children,
+// see Partial.
+func (x *index) partial() *Node {
+	spans := len(x.spans)
+	if len(x.open) > 1 {
+		spans = int(x.open[1])
+	}
+	c := &index{
+		data:    x.data,
+		spans:   append([]span(nil), x.spans[:spans]...),
+		marks:   map[int32][]mark{},
+		stream:  x.stream,
+		invalid: map[int]*Node{},
+	}
+
+	root := &c.spans[0]
+	root.end, root.next = x.done, int32(spans)
+	root.lines = x.doneLine - root.line
+	if !x.stream {
+		root.lines++
+		if root.count == 0 {
+			root.lines = 1
+		}
+	}
+	for s, marks := range x.marks {
+		if int(s) < spans {
+			c.marks[s] = marks
+		}
+	}
+	if marks := x.marks[0]; len(marks) > 0 {
+		n := min(max(int(root.count)-1, 0)/markInterval, len(marks))
+		c.marks[0] = marks[:n:n]
+	}
+	for offset, n := range x.invalid {
+		if offset < x.done {
+			c.invalid[offset] = n
+		}
+	}
+
+	c.root = &Node{Type: x.root.Type, index: c, Start: x.root.Start, End: Position{Offset: root.end}}
+	return c.root
+}
+
+// scan calls fn for the children of the container s in the order of the
+// source, from the one with the given index on, until fn returns false.
+func (x *index) scan(s int32, from int, fn func(e entry) bool) {
+	sp := x.spans[s]
+	records := x.stream && s == 0
+	object := !records && x.data[sp.start] == '{'
+
+	i, offset, next := 0, sp.start+1, s+1
+	if records {
+		offset = sp.start
+	}
+	if m := min(from/markInterval, len(x.marks[s])); m > 0 {
+		mk := x.marks[s][m-1]
+		i, offset, next = m*markInterval, mk.offset, mk.span
+	}
+
+	p := &parser{data: x.data, pos: Position{Offset: offset}}
+	for ; i < int(sp.count); i++ {
+		p.skipWhitespace()
+		e := entry{index: i, offset: p.pos.Offset, span: -1}
+		if object {
+			p.skipString()
+			p.skipWhitespace()
+			p.advance()
+			p.skipWhitespace()
+		}
+		e.value = p.pos.Offset
+
+		switch c := x.data[e.value]; {
+		case records && x.invalid[e.value] != nil:
+			p.pos = x.invalid[e.value].End
+		case c == '{' || c == '[':
+			e.span = next
+			p.pos.Offset = x.spans[next].end
+			next = x.spans[next].next
+		default:
+			p.skipScalar()
+		}
+		if i >= from && !fn(e) {
+			return
+		}
+
+		// Records are not separated by commas, a comma between them is
+		// an invalid record of its own, see indexRecords.
+		p.skipWhitespace()
+		if c, ok := p.peek(); ok && c == ',' && !records {
+			p.advance()
+		}
+	}
+}
+
+// skipScalar moves past the scalar at the current position, which is known
+// to be valid.
+func (p *parser) skipScalar() {
+	switch c, _ := p.peek(); {
+	case c == '"':
+		p.skipString()
+	case c == 't' || c == 'n':
+		p.advanceBy(4)
+	case c == 'f':
+		p.advanceBy(5)
+	default:
+		p.skipNumber()
+	}
+}
+
+// decode returns the node of the child e of parent.
+func (x *index) decode(parent *Node, e entry) *Node {
+	n := &Node{Parent: parent, Index: e.index, index: x, span: e.span}
+	p := &parser{data: x.data, pos: Position{Offset: e.offset}}
+	if parent.Type == Object {
+		n.KeyLiteral, n.Key, _ = p.parseString()
+	}
+
+	p.pos = Position{Offset: e.value}
+	if invalid := x.invalid[e.value]; invalid != nil && parent.Type == Stream {
+		n.Type, n.Literal, n.Err = Invalid, invalid.Literal, invalid.Err
+		p.pos = invalid.End
+	} else if e.span >= 0 {
+		n.Type = Array
+		if x.data[e.value] == '{' {
+			n.Type = Object
+		}
+		p.pos.Offset = x.spans[e.span].end
+	} else {
+		v, _ := p.parseValue(parent, e.index)
+		n.Type, n.Literal, n.Value = v.Type, v.Literal, v.Value
+	}
+
+	n.Start, n.End = Position{Offset: e.value}, Position{Offset: p.pos.Offset}
+	n.Duplicate = x.duplicates[e.value]
+	return n
+}
+
+// node returns the node of the container s, decoding its ancestors.
+func (x *index) node(s int32) *Node {
+	if s == 0 {
+		return x.root
+	}
+
+	// The last child with a mark at or before s leads to it.
+	ps := x.spans[s].parent
+	marks := x.marks[ps]
+	from := markInterval * sort.Search(len(marks), func(i int) bool { return marks[i].span > s })
+
+	parent := x.node(ps)
+	var n *Node
+	x.scan(ps, from, func(e entry) bool {
+		if e.span == s {
+			n = x.decode(parent, e)
+		}
+		return e.span < s
+	})
+	return n
+}
+
+// findDuplicates is FindDuplicates for the container s and its descendants.
+func (x *index) findDuplicates(s int32) []*Node {
+	if x.duplicates == nil {
+		x.duplicates = map[int]bool{}
+	}
+
+	var objects, members []*Node
+	seen := map[string]*Node{}
+	for id := s; id < x.spans[s].next; id++ {
+		if x.stream && id == 0 || x.spans[id].count < 2 || x.data[x.spans[id].start] != '{' {
+			continue
+		}
+		members = members[:0]
+		x.scan(id, 0, func(e entry) bool {
+			p := &parser{data: x.data, pos: Position{Offset: e.offset}}
+			literal, key, _ := p.parseString()
+			members = append(members, &Node{Key: key, KeyLiteral: literal, Start: Position{Offset: e.value}})
+			return true
+		})
+		if markDuplicates(members, seen) {
+			for _, m := range members {
+				if m.Duplicate {
+					x.duplicates[m.Start.Offset] = true
+				}
+			}
+			objects = append(objects, x.node(id))
+		}
+	}
+	return objects
+}
+
+// Indexed reports whether n is part of a value read with IndexJSON or
+// IndexStream. The children of such nodes are not held in Children but
+// decoded whenever they are used, see Range, and their positions only have
+// an Offset.
+func (n *Node) Indexed() bool {
+	return n.index != nil
+}
+
+// indexed returns the index and span of n if it is an indexed container.
+func (n *Node) indexed() (*index, int32, bool) {
+	return n.index, n.span, n.index != nil && n.span >= 0
+}
+
+// Len returns the number of children of n.
+func (n *Node) Len() int {
+	if x, s, ok := n.indexed(); ok {
+		return int(x.spans[s].count)
+	}
+	return len(n.Children)
+}
+
+// Range calls fn for the children of n in order, from the one with the
+// given index on, until fn returns false. Unlike Children it works for
+// indexed nodes, whose children it decodes.
+func (n *Node) Range(from int, fn func(child *Node) bool) {
+	if x, s, ok := n.indexed(); ok {
+		x.scan(s, from, func(e entry) bool { return fn(x.decode(n, e)) })
+		return
+	}
+	for _, child := range n.Children[min(from, len(n.Children)):] {
+		if !fn(child) {
+			return
+		}
+	}
+}
+
+// Child returns the child of n with the given index, or nil if there is
+// none.
+func (n *Node) Child(i int) *Node {
+	var child *Node
+	n.Range(i, func(c *Node) bool {
+		child = c
+		return false
+	})
+	return child
+}
+
+// Same reports whether n and m are the same value. Indexed nodes are decoded
+// anew whenever they are used, so they are compared by their position.
+func (n *Node) Same(m *Node) bool {
+	if n == nil || m == nil || n.index == nil {
+		return n == m
+	}
+	return n.index == m.index && n.Type == m.Type && n.Start.Offset == m.Start.Offset
+}
@@ -0,0 +1,271 @@
+package jsonast
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type NodeType int
+
+const (
+	Object NodeType = iota
+	Array
+	String
+	Number
+	Bool
+	Null
+
+	// DateTime is a date, time or date-time value, e.g. from TOML.
+	DateTime
+
+	// Bytes is binary data, e.g. from MessagePack or CBOR. Value holds the
+	// raw bytes.
+	Bytes
+
+	// Stream is the root of a sequence of values, e.g. JSON Lines. Each
+	// child is a record.
+	Stream
+
+	// Invalid holds the source of a record or value that could not be
+	// parsed.
+	Invalid
+)
+
+var nodeTypeNames = map[NodeType]string{
+	Object:   "object",
+	Array:    "array",
+	String:   "string",
+	Number:   "number",
+	Bool:     "bool",
+	Null:     "null",
+	DateTime: "datetime",
+	Bytes:    "bytes",
+	Stream:   "stream",
+	Invalid:  "invalid",
+}
+
+func (t NodeType) String() string {
+	return nodeTypeNames[t]
+}
+
+// Position is a location in the source. Line and Column start at 1, the
+// column is counted in runes. Positions in binary input only have an Offset.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+type Node struct {
+	Type     NodeType
+	Parent   *Node
+	Children []*Node
+
+	// Index is the position of the node within its parent.
+	Index int
+
+	// Key is the decoded name of an object member, KeyLiteral the name as
+	// written in the source including the quotes.
+	Key        string
+	KeyLiteral string
+
+	// Duplicate is set by FindDuplicates if another member of the same
+	// object has the same key.
+	Duplicate bool
+
+	// Literal is the source text of a scalar. For strings Value holds the
+	// decoded text. Numbers are in JSON notation, or Infinity, -Infinity or
+	// NaN; Source holds the text written in the source if it differs, e.g.
+	// 0x1F in YAML.
+	Literal string
+	Value   string
+	Source  string
+
+	// Err is the reason an Invalid node could not be parsed, or the problem
+	// found at a node by ParseRecover.
+	Err error
+
+	// Comments holds the comments preceding the node and LineComment one
+	// following it on the same line. EndComments are the comments before
+	// the closing bracket of a container, TrailingComments those after a
+	// top-level value. Comments are only kept by ParseLenient, ParseRecover,
+	// ParseYAML and ParseTOML.
+	Comments         []string
+	LineComment      string
+	EndComments      []string
+	TrailingComments []string
+
+	// Start and End delimit the value in the source, End is exclusive.
+	Start, End Position
+
+	// index and span locate indexed nodes, see Indexed; span is -1 for
+	// scalars.
+	index *index
+	span  int32
+}
+
+func (n *Node) IsContainer() bool {
+	return n.Type == Object || n.Type == Array
+}
+
+// Depth returns the nesting level of the node, 0 for the top-level value
+// or a record of a stream.
+func (n *Node) Depth() int {
+	depth := 0
+	for p := n.Parent; p != nil && p.Type != Stream; p = p.Parent {
+		depth++
+	}
+	return depth
+}
+
+// Record returns the number of the stream record containing the node,
+// starting at 1, or 0 if the node is not part of a stream.
+func (n *Node) Record() int {
+	for ; n.Parent != nil; n = n.Parent {
+		if n.Parent.Type == Stream {
+			return n.Index + 1
+		}
+	}
+	return 0
+}
+
+// Path returns the location of the node in jq syntax, e.g. .foo[2]["a b"].
+// Paths within a stream are relative to the record, like those returned by
+// the other path methods.
+func (n *Node) Path() string {
+	var b strings.Builder
+	for _, node := range n.pathNodes() {
+		b.WriteString(node.pathSegment())
+	}
+
+	path := b.String()
+	if !strings.HasPrefix(path, ".") {
+		path = "." + path
+	}
+	return path
+}
+
+// Location returns the path of the node prefixed with its record in a
+// stream, e.g. #3 .foo[2], as shown in the status bar.
+func (n *Node) Location() string {
+	if record := n.Record(); record > 0 {
+		return fmt.Sprintf("#%d %s", record, n.Path())
+	}
+	return n.Path()
+}
+
+// Pointer returns the location of the node as a JSON Pointer (RFC 6901),
+// e.g. /foo/2/a~1b for the member "a/b".
+func (n *Node) Pointer() string {
+	var b strings.Builder
+	for _, node := range n.pathNodes() {
+		b.WriteByte('/')
+		if node.Parent.Type == Array {
+			b.WriteString(strconv.Itoa(node.Index))
+		} else {
+			b.WriteString(pointerEscaper.Replace(node.Key))
+		}
+	}
+	return b.String()
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// JSPath returns the JavaScript expression accessing the node within the
+// variable base, e.g. data.foo[2]["a b"].
+func (n *Node) JSPath(base string) string {
+	var b strings.Builder
+	b.WriteString(base)
+	for _, node := range n.pathNodes() {
+		b.WriteString(node.pathSegment())
+	}
+	return b.String()
+}
+
+// PythonPath returns the subscripts accessing the node within the variable
+// base in Python, e.g. data["foo"][2]["a b"].
+func (n *Node) PythonPath(base string) string {
+	var b strings.Builder
+	b.WriteString(base)
+	for _, node := range n.pathNodes() {
+		if node.Parent.Type == Array {
+			b.WriteString("[" + strconv.Itoa(node.Index) + "]")
+		} else {
+			b.WriteString("[" + Quote(node.Key) + "]")
+		}
+	}
+	return b.String()
+}
+
+// pathNodes returns the nodes from the top-level value or the record
+// containing n down to n, excluding the former.
+func (n *Node) pathNodes() []*Node {
+	var nodes []*Node
+	for ; n.Parent != nil && n.Parent.Type != Stream; n = n.Parent {
+		nodes = append(nodes, n)
+	}
+
+	for i, j := 0, len(nodes)-1; i < j; i, j = i+1, j-1 {
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	}
+	return nodes
+}
+
+func (n *Node) pathSegment() string {
+	if n.Parent.Type == Array {
+		return "[" + strconv.Itoa(n.Index) + "]"
+	}
+	if isIdentifier(n.Key) {
+		return "." + n.Key
+	}
+	return "[" + Quote(n.Key) + "]"
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case r == '_', 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z':
+		case i > 0 && '0' <= r && r <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Quote returns s as a JSON string literal.
+func Quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		default:
+			if r < 0x20 || r == utf8.RuneError {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,1013 @@
+package jsonast
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	input := "{\n  \"foo\": [1, \"a\\nb\", true],\n  \"ä\": null\n}"
+	root, err := Parse([]byte(input))
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", input, err)
+	}
+
+	if root.Type != Object || len(root.Children) != 2 {
+		t.Fatalf("root: %v with %d children, want object with 2", root.Type, len(root.Children))
+	}
+
+	foo := root.Children[0]
+	if foo.Key != "foo" || foo.KeyLiteral != `"foo"` || foo.Type != Array || len(foo.Children) != 3 {
+		t.Errorf("foo: %+v", foo)
+	}
+
+	str := foo.Children[1]
+	if str.Type != String || str.Literal != `"a\nb"` || str.Value != "a\nb" {
+		t.Errorf("string: literal %q, value %q", str.Literal, str.Value)
+	}
+
+	null := root.Children[1]
+	if null.Start != (Position{Offset: 38, Line: 3, Column: 8}) {
+		t.Errorf("null start: %+v", null.Start)
+	}
+	if null.End != (Position{Offset: 42, Line: 3, Column: 12}) {
+		t.Errorf("null end: %+v", null.End)
+	}
+	if input[null.Start.Offset:null.End.Offset] != "null" {
+		t.Errorf("null span: %q", input[null.Start.Offset:null.End.Offset])
+	}
+}
+
+var stringExamples = []struct {
+	input    string
+	expected string
+}{
+	{`"plain"`, "plain"},
+	{`"\"\\\/\b\f\n\r\t"`, "\"\\/\b\f\n\r\t"},
+	{`"café"`, "café"},
+	{`"😀"`, "😀"},
+	{`"\ud83d"`, "�"},
+}
+
+func TestParseStrings(t *testing.T) {
+	for _, tt := range stringExamples {
+		n, err := Parse([]byte(tt.input))
+		if err != nil {
+			t.Errorf("Parse(%v): %v", tt.input, err)
+			continue
+		}
+		if n.Value != tt.expected || n.Literal != tt.input {
+			t.Errorf("Parse(%v): %q, want %q", tt.input, n.Value, tt.expected)
+		}
+	}
+}
+
+var invalidExamples = []struct {
+	input string
+	pos   Position
+}{
+	{``, Position{0, 1, 1}},
+	{`{`, Position{1, 1, 2}},
+	{`{"foo" 1}`, Position{7, 1, 8}},
+	{"[1,\n]", Position{4, 2, 1}},
+	{`{} {}`, Position{3, 1, 4}},
+	{`01`, Position{1, 1, 2}},
+	{`1.`, Position{2, 1, 3}},
+	{`"a` + "\n" + `"`, Position{2, 1, 3}},
+	{`"\x"`, Position{2, 1, 3}},
+	{`nul`, Position{3, 1, 4}},
+	{`["ä", x]`, Position{7, 1, 7}},
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, tt := range invalidExamples {
+		_, err := Parse([]byte(tt.input))
+		syntaxErr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("Parse(%v): %v, want syntax error", tt.input, err)
+			continue
+		}
+		if syntaxErr.Pos != tt.pos {
+			t.Errorf("Parse(%v): error at %+v, want %+v", tt.input, syntaxErr.Pos, tt.pos)
+		}
+	}
+}
+
+func TestPath(t *testing.T) {
+	root, err := Parse([]byte(`{"spec": {"containers": [{"a b": 1, "_x1": [2]}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	container := root.Children[0].Children[0].Children[0]
+	examples := []struct {
+		node     *Node
+		expected string
+	}{
+		{root, "."},
+		{root.Children[0], ".spec"},
+		{container, ".spec.containers[0]"},
+		{container.Children[0], `.spec.containers[0]["a b"]`},
+		{container.Children[1].Children[0], ".spec.containers[0]._x1[0]"},
+	}
+
+	for _, tt := range examples {
+		if actual := tt.node.Path(); actual != tt.expected {
+			t.Errorf("Path(): %v, want %v", actual, tt.expected)
+		}
+	}
+
+	arr, _ := Parse([]byte(`[[1]]`))
+	if actual := arr.Children[0].Children[0].Path(); actual != ".[0][0]" {
+		t.Errorf("Path(): %v, want .[0][0]", actual)
+	}
+
+	if actual := container.Location(); actual != ".spec.containers[0]" {
+		t.Errorf("Location(): %v, want .spec.containers[0]", actual)
+	}
+	stream := ParseStream([]byte("1\n{\"a\": [true]}"))
+	if actual := stream.Children[1].Children[0].Children[0].Location(); actual != "#2 .a[0]" {
+		t.Errorf("Location(): %v, want #2 .a[0]", actual)
+	}
+}
+
+func TestPathStyles(t *testing.T) {
+	root, err := Parse([]byte(`{"spec": {"containers": [{"a/b~": 1, "_x1": [2]}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	container := root.Children[0].Children[0].Children[0]
+	examples := []struct {
+		node                *Node
+		pointer, js, python string
+	}{
+		{root, "", "data", "data"},
+		{container, "/spec/containers/0", "data.spec.containers[0]", `data["spec"]["containers"][0]`},
+		{container.Children[0], "/spec/containers/0/a~1b~0", `data.spec.containers[0]["a/b~"]`, `data["spec"]["containers"][0]["a/b~"]`},
+		{container.Children[1].Children[0], "/spec/containers/0/_x1/0", "data.spec.containers[0]._x1[0]", `data["spec"]["containers"][0]["_x1"][0]`},
+	}
+
+	for _, tt := range examples {
+		if actual := tt.node.Pointer(); actual != tt.pointer {
+			t.Errorf("Pointer(): %v, want %v", actual, tt.pointer)
+		}
+		if actual := tt.node.JSPath("data"); actual != tt.js {
+			t.Errorf("JSPath(): %v, want %v", actual, tt.js)
+		}
+		if actual := tt.node.PythonPath("data"); actual != tt.python {
+			t.Errorf("PythonPath(): %v, want %v", actual, tt.python)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	examples := []struct {
+		input    string
+		expected string
+	}{
+		{`{ "a" : [1, 2.50, true, null], "b\u0041": "x\/y", "c": {} }`, `{"a":[1,2.50,true,null],"bA":"x/y","c":{}}`},
+		{"// c\n{a: 'x', b: [0x1F,],}", `{"a":"x","b":[31]}`},
+		{`[NaN, -Infinity, +5, .5, 5.]`, `["NaN","-Infinity",5,0.5,5.0]`},
+		{`[]`, `[]`},
+	}
+	for _, tt := range examples {
+		root, err := ParseLenient([]byte(tt.input))
+		if err != nil {
+			t.Fatalf("ParseLenient(%q): %v", tt.input, err)
+		}
+		if actual := Compact(root); actual != tt.expected {
+			t.Errorf("Compact(%q): %v, want %v", tt.input, actual, tt.expected)
+		}
+	}
+
+	stream := ParseStream([]byte("{\"a\": 1}\n[2]"))
+	if actual, expected := Compact(stream), "{\"a\":1}\n[2]"; actual != expected {
+		t.Errorf("Compact(stream): %q, want %q", actual, expected)
+	}
+	bytes := &Node{Type: Bytes, Value: "\xca\xfe"}
+	if actual, expected := Compact(bytes), `"yv4="`; actual != expected {
+		t.Errorf("Compact(bytes): %v, want %v", actual, expected)
+	}
+
+	input := "{a: [1, {}], 'b': {c: Infinity}, d: []}"
+	root, _ := ParseLenient([]byte(input))
+	expected := "{\n  \"a\": [\n    1,\n    {}\n  ],\n  \"b\": {\n    \"c\": \"Infinity\"\n  },\n  \"d\": []\n}"
+	if actual := Indent(root, "  "); actual != expected {
+		t.Errorf("Indent(%q):\n%v\nwant:\n%v", input, actual, expected)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	input := "a\"b\\c\nd\x01é"
+	expected := `"a\"b\\c\nd\u0001é"`
+	if actual := Quote(input); actual != expected {
+		t.Errorf("Quote(%q): %v, want %v", input, actual, expected)
+	}
+}
+
+func TestParseStream(t *testing.T) {
+	input := "{\"a\": [1]}\n\n{\"a\": tru}\n[] [2]\r\n\"x"
+	stream := ParseStream([]byte(input))
+
+	var actual []string
+	for _, n := range stream.Children {
+		actual = append(actual, fmt.Sprintf("%d %v %s", n.Record(), n.Type, input[n.Start.Offset:n.End.Offset]))
+	}
+
+	expected := []string{
+		"1 object {\"a\": [1]}",
+		"2 invalid {\"a\": tru}\n",
+		"3 array []",
+		"4 array [2]",
+		"5 invalid \"x",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("ParseStream(%q):\n%q\nwant:\n%q", input, actual, expected)
+	}
+
+	if lit := stream.Children[1].Literal; lit != `{"a": tru}` {
+		t.Errorf("Literal: %q", lit)
+	}
+
+	a := stream.Children[0].Children[0].Children[0]
+	if a.Path() != ".a[0]" || a.Depth() != 2 || a.Record() != 1 {
+		t.Errorf("path %v, depth %d, record %d", a.Path(), a.Depth(), a.Record())
+	}
+
+	input = "{\"a\":\n{\"b\":1}\n{\"c\":2}\n{\"d\":3}"
+	stream = ParseStream([]byte(input))
+
+	actual = nil
+	for _, n := range stream.Children {
+		actual = append(actual, fmt.Sprintf("%d %v %s", n.Record(), n.Type, input[n.Start.Offset:n.End.Offset]))
+	}
+
+	expected = []string{
+		"1 invalid {\"a\":\n",
+		"2 object {\"b\":1}",
+		"3 object {\"c\":2}",
+		"4 object {\"d\":3}",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("ParseStream(%q):\n%q\nwant:\n%q", input, actual, expected)
+	}
+	if err := stream.Children[0].Err.Error(); err != "unexpected end of line at line 1, column 6" {
+		t.Errorf("Err: %v", err)
+	}
+}
+
+func TestParseLenient(t *testing.T) {
+	input := `{
+  // leading
+  unquoted: 'single \'quoted\'', /* same line */
+  $id2: [0x1F, +1, .5, 5., -Infinity, NaN,],
+  "multi": 'line \
+continued \x41\v',
+}`
+	root, err := ParseLenient([]byte(input))
+	if err != nil {
+		t.Fatalf("ParseLenient(%v): %v", input, err)
+	}
+
+	unquoted := root.Children[0]
+	if unquoted.Key != "unquoted" || unquoted.KeyLiteral != "unquoted" {
+		t.Errorf("key: %q, literal %q", unquoted.Key, unquoted.KeyLiteral)
+	}
+	if unquoted.Value != "single 'quoted'" || unquoted.Literal != `'single \'quoted\''` {
+		t.Errorf("value: %q, literal %q", unquoted.Value, unquoted.Literal)
+	}
+	if !reflect.DeepEqual(unquoted.Comments, []string{"// leading"}) || unquoted.LineComment != "/* same line */" {
+		t.Errorf("comments: %q, line comment %q", unquoted.Comments, unquoted.LineComment)
+	}
+
+	var numbers []string
+	for _, n := range root.Children[1].Children {
+		numbers = append(numbers, n.Literal)
+	}
+	expected := []string{"0x1F", "+1", ".5", "5.", "-Infinity", "NaN"}
+	if !reflect.DeepEqual(numbers, expected) {
+		t.Errorf("numbers: %q, want %q", numbers, expected)
+	}
+
+	if multi := root.Children[2]; multi.Value != "line continued A\v" {
+		t.Errorf("multi: %q", multi.Value)
+	}
+
+	for _, input := range []string{`{a: 1}`, `[1,]`, `// c` + "\n1", `'a'`, `0x1`, `.5`} {
+		if _, err := Parse([]byte(input)); err == nil {
+			t.Errorf("Parse(%v): expected error", input)
+		}
+		if _, err := ParseLenient([]byte(input)); err != nil {
+			t.Errorf("ParseLenient(%v): %v", input, err)
+		}
+	}
+
+	for _, input := range []string{`[1,,]`, `{,}`, `/* open`, `{1: 2}`, `0x`, `"\01"`} {
+		if _, err := ParseLenient([]byte(input)); err == nil {
+			t.Errorf("ParseLenient(%v): expected error", input)
+		}
+	}
+}
+
+// describe lists the path, type and literal of every scalar below n.
+func describe(n *Node) []string {
+	var scalars []string
+	if !n.IsContainer() {
+		literal := n.Literal
+		if n.Type == Bytes {
+			literal = fmt.Sprintf("%x", n.Value)
+		}
+		scalars = append(scalars, fmt.Sprintf("%s %v %s", n.Path(), n.Type, literal))
+	}
+	for _, child := range n.Children {
+		scalars = append(scalars, describe(child)...)
+	}
+	return scalars
+}
+
+func TestParseRecover(t *testing.T) {
+	input := `{"a": 1, "b": tru, "c": [1 2], "d": {"x": }`
+	expected := []string{
+		`.a number 1`,
+		`.b invalid tru`,
+		`.c[0] number 1`,
+		`.c[1] number 2`,
+		`.d.x invalid `,
+	}
+	problems := []string{
+		`invalid character ',' in literal true (expecting 'e') at line 1, column 18`,
+		`invalid character '2' after array element at line 1, column 28`,
+		`invalid character '}' looking for beginning of value at line 1, column 43`,
+		`unexpected end of JSON input at line 1, column 44`,
+	}
+
+	root, nodes := ParseRecover([]byte(input))
+	if actual := describe(root); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("ParseRecover(%q):\n%q\nwant:\n%q", input, actual, expected)
+	}
+	var actual []string
+	for _, n := range nodes {
+		actual = append(actual, n.Err.Error())
+	}
+	if !reflect.DeepEqual(actual, problems) {
+		t.Errorf("ParseRecover(%q) problems:\n%q\nwant:\n%q", input, actual, problems)
+	}
+	if root.Err == nil || nodes[3] != root {
+		t.Errorf("ParseRecover(%q): root not closed automatically", input)
+	}
+
+	examples := []struct {
+		input    string
+		records  int
+		problems int
+	}{
+		{`{"a": [1, 2]}`, 1, 0},
+		{`[1, {"a": 2]]`, 2, 2},
+		{`[[[`, 1, 1},
+		{``, 0, 1},
+	}
+	for _, tt := range examples {
+		root, nodes := ParseRecover([]byte(tt.input))
+		records := 1
+		if root.Type == Stream {
+			records = len(root.Children)
+		} else if root.Type == Invalid {
+			records = 0
+		}
+		if records != tt.records || len(nodes) != tt.problems {
+			t.Errorf("ParseRecover(%q): %d records, %d problems, want %d, %d", tt.input, records, len(nodes), tt.records, tt.problems)
+		}
+	}
+}
+
+func TestParseProgress(t *testing.T) {
+	input := "[" + strings.Repeat(`{"a": [1, 2]}, `, 3*progressInterval) + "0]"
+
+	var calls, partial int
+	root, err := ParseProgress([]byte(input), false, func(offset int, top *Node) bool {
+		calls++
+		copied := Partial(top)
+		partial = len(copied.Children)
+		if offset <= 0 || offset >= len(input) || partial != len(top.Children) {
+			t.Errorf("Progress(%d, %d children): partial has %d children", offset, len(top.Children), partial)
+		}
+		if child := copied.Children[partial-1]; child == top.Children[partial-1] || child.Parent != copied || child.Children[0].Parent != child {
+			t.Errorf("Progress(%d): partial shares nodes with the value being parsed", offset)
+		}
+		return true
+	})
+	if err != nil || len(root.Children) != 3*progressInterval+1 {
+		t.Fatalf("ParseProgress: %v", err)
+	}
+	if calls != 3*4 || partial == 0 {
+		t.Errorf("ParseProgress: %d calls, %d children in the last partial value", calls, partial)
+	}
+
+	if _, err := ParseProgress([]byte(input), false, func(int, *Node) bool { return false }); err != ErrCanceled {
+		t.Errorf("ParseProgress canceled: %v, want %v", err, ErrCanceled)
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	input := `{"a": 1, "b": {"x": 1, "y": 2, "x": 3}, "a": [{"k": 1, "k": 2}], "c": {"a": 1}, "a": 4}`
+	root, err := Parse([]byte(input))
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", input, err)
+	}
+
+	var paths []string
+	for _, n := range FindDuplicates(root) {
+		paths = append(paths, n.Path())
+	}
+	if expected := []string{".", ".b", ".a[0]"}; !reflect.DeepEqual(paths, expected) {
+		t.Errorf("FindDuplicates(%q): %q, want %q", input, paths, expected)
+	}
+
+	var duplicates []string
+	var walk func(n *Node)
+	walk = func(n *Node) {
+		if n.Duplicate {
+			duplicates = append(duplicates, n.Path())
+		}
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+	walk(root)
+	expected := []string{".a", ".b.x", ".b.x", ".a", ".a[0].k", ".a[0].k", ".a"}
+	if !reflect.DeepEqual(duplicates, expected) {
+		t.Errorf("FindDuplicates(%q) marked %q, want %q", input, duplicates, expected)
+	}
+
+	members := make([]string, 2*smallObject)
+	for i := range members {
+		members[i] = fmt.Sprintf(`"k%d": %d`, i%(smallObject+1), i)
+	}
+	large, _ := Parse([]byte("{" + strings.Join(members, ", ") + "}"))
+	if objects := FindDuplicates(large); len(objects) != 1 {
+		t.Fatalf("FindDuplicates: %d objects, want 1", len(objects))
+	}
+	for i, member := range large.Children {
+		if duplicate := i < smallObject-1 || i > smallObject; member.Duplicate != duplicate {
+			t.Errorf("FindDuplicates: member %d duplicate %v, want %v", i, member.Duplicate, duplicate)
+		}
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	input := `# config
+name: "my app"   # quoted
+tags: [web, 'front end', 3]
+spec:
+  replicas: 2
+  enabled: True
+  ratio: .5
+  missing:
+  containers:
+  - name: web
+    args:
+      - --port
+      - 80
+  script: |
+    echo hi
+      indented
+  folded: >-
+    one
+    two
+
+    three
+  plain: first
+    second
+  base: &base {a: 1}
+  copy: *base
+  str: !!str 12
+  hex: 0x1F
+  octal: 0o17
+  decimal: 012
+  inf: -.inf
+  tagged: !!str 0x1F
+`
+	root, err := ParseYAML([]byte(input))
+	if err != nil {
+		t.Fatalf("ParseYAML(%v): %v", input, err)
+	}
+
+	expected := []string{
+		`.name string "my app"`,
+		`.tags[0] string "web"`,
+		`.tags[1] string "front end"`,
+		`.tags[2] number 3`,
+		`.spec.replicas number 2`,
+		`.spec.enabled bool true`,
+		`.spec.ratio number 0.5`,
+		`.spec.missing null null`,
+		`.spec.containers[0].name string "web"`,
+		`.spec.containers[0].args[0] string "--port"`,
+		`.spec.containers[0].args[1] number 80`,
+		`.spec.script string "echo hi\n  indented\n"`,
+		`.spec.folded string "one two\nthree"`,
+		`.spec.plain string "first second"`,
+		`.spec.base.a number 1`,
+		`.spec.copy.a number 1`,
+		`.spec.str string "12"`,
+		`.spec.hex number 31`,
+		`.spec.octal number 15`,
+		`.spec.decimal number 12`,
+		`.spec.inf number -Infinity`,
+		`.spec.tagged string "0x1F"`,
+	}
+	if actual := describe(root); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("ParseYAML(%v):\n%q\nwant:\n%q", input, actual, expected)
+	}
+
+	name := root.Children[0]
+	if !reflect.DeepEqual(root.Comments, []string{"# config"}) || name.LineComment != "# quoted" {
+		t.Errorf("comments: %q, line comment %q", root.Comments, name.LineComment)
+	}
+	if name.Start != (Position{Offset: 15, Line: 2, Column: 7}) {
+		t.Errorf("name start: %+v", name.Start)
+	}
+	if hex := root.Children[2].Children[11]; hex.Source != "0x1F" {
+		t.Errorf("hex source: %q", hex.Source)
+	}
+}
+
+func TestParseYAMLDocuments(t *testing.T) {
+	stream, err := ParseYAML([]byte("a: 1\n---\n- x\n--- foo\n...\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream.Type != Stream || len(stream.Children) != 3 {
+		t.Fatalf("stream: %v with %d children, want stream with 3", stream.Type, len(stream.Children))
+	}
+	if x := stream.Children[1].Children[0]; x.Record() != 2 || x.Path() != ".[0]" || x.Value != "x" {
+		t.Errorf("record %d, path %v, value %q", x.Record(), x.Path(), x.Value)
+	}
+
+	for _, input := range []string{"a: [1, 2\n", "a: \"x\n", "x: *nope\n", "? a\n: b\n", "a: 'x' y\n", "- a\nb: 1\n", "a: b: c\n", "a: b\n  c: d\n"} {
+		if _, err := ParseYAML([]byte(input)); err == nil {
+			t.Errorf("ParseYAML(%q): expected error", input)
+		}
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	input := `# service
+title = "jv" # name
+
+[server]
+port = 8_080
+started = 1979-05-27 07:32:00
+tags = [ "a", 'b', ]
+limits = { cpu = 1.5, memory.max = "1G" }
+
+[server.tls]
+enabled = true
+
+[[routes]]
+path = """
+/api\
+  /v1"""
+
+[[routes]]
+path = '''C:\'''
+`
+	root, err := ParseTOML([]byte(input))
+	if err != nil {
+		t.Fatalf("ParseTOML(%v): %v", input, err)
+	}
+
+	expected := []string{
+		`.title string "jv"`,
+		`.server.port number 8080`,
+		`.server.started datetime 1979-05-27 07:32:00`,
+		`.server.tags[0] string "a"`,
+		`.server.tags[1] string "b"`,
+		`.server.limits.cpu number 1.5`,
+		`.server.limits.memory.max string "1G"`,
+		`.server.tls.enabled bool true`,
+		`.routes[0].path string "/api/v1"`,
+		`.routes[1].path string "C:\\"`,
+	}
+	if actual := describe(root); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("ParseTOML(%v):\n%q\nwant:\n%q", input, actual, expected)
+	}
+
+	title, server := root.Children[0], root.Children[1]
+	if !reflect.DeepEqual(title.Comments, []string{"# service"}) || title.LineComment != "# name" {
+		t.Errorf("comments: %q, line comment %q", title.Comments, title.LineComment)
+	}
+	if server.Start != (Position{Offset: 31, Line: 4, Column: 1}) {
+		t.Errorf("server start: %+v", server.Start)
+	}
+	if port := server.Children[0]; port.Source != "8_080" {
+		t.Errorf("port source: %q", port.Source)
+	}
+
+	numbers, err := ParseTOML([]byte("a = [1_000, 0x1F, 0o17, 0b101, +5, inf, -inf, nan, 1e3]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var literals []string
+	for _, n := range numbers.Children[0].Children {
+		literals = append(literals, n.Literal)
+	}
+	if expected := []string{"1000", "31", "15", "5", "5", "Infinity", "-Infinity", "NaN", "1e3"}; !reflect.DeepEqual(literals, expected) {
+		t.Errorf("numbers: %q, want %q", literals, expected)
+	}
+
+	for _, input := range []string{"a = 1\na = 2", "[a]\n[a]", "a = {b = 1}\n[a]", "a = 1 b = 2", "a = \"x", "a = tru", "a = [1 2]", "[[a]]\n[a]"} {
+		if _, err := ParseTOML([]byte(input)); err == nil {
+			t.Errorf("ParseTOML(%q): expected error", input)
+		}
+	}
+}
+
+// TestParseLinear makes sure the line based formats do not copy the rest
+// of the input for every line, which made parsing larger files quadratic.
+func TestParseLinear(t *testing.T) {
+	tests := []struct {
+		name  string
+		parse func([]byte) (*Node, error)
+		line  string
+	}{
+		{"YAML", ParseYAML, "- key: value # comment\n"},
+		{"TOML", ParseTOML, "# comment\n[[table]]\nkey = 'value' # comment\n"},
+	}
+
+	for _, tt := range tests {
+		input := []byte(strings.Repeat(tt.line, (1<<20)/len(tt.line)))
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		if _, err := tt.parse(input); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		runtime.ReadMemStats(&after)
+
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 128*uint64(len(input)) {
+			t.Errorf("%s: %d bytes allocated for %d bytes of input", tt.name, allocated, len(input))
+		}
+	}
+}
+
+func TestParseCSV(t *testing.T) {
+	input := "name,age,,note\nbob,42,true,\"two\nlines\"\nälice,007\n"
+	examples := []struct {
+		inferTypes bool
+		expected   []string
+	}{
+		{false, []string{
+			`[0].name string "bob"`,
+			`[0].age string "42"`,
+			`[0]["3"] string "true"`,
+			`[0].note string "two\nlines"`,
+			`[1].name string "älice"`,
+			`[1].age string "007"`,
+		}},
+		{true, []string{
+			`[0].name string "bob"`,
+			`[0].age number 42`,
+			`[0]["3"] bool true`,
+			`[0].note string "two\nlines"`,
+			`[1].name string "älice"`,
+			`[1].age string "007"`,
+		}},
+	}
+
+	for _, tt := range examples {
+		root, err := ParseCSV([]byte(input), ',', tt.inferTypes)
+		if err != nil {
+			t.Fatalf("ParseCSV(%q): %v", input, err)
+		}
+
+		var actual []string
+		for _, row := range root.Children {
+			for _, n := range row.Children {
+				actual = append(actual, fmt.Sprintf("%s %v %s", n.Path()[1:], n.Type, n.Literal))
+			}
+		}
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("ParseCSV(%q, %v):\n%q\nwant:\n%q", input, tt.inferTypes, actual, tt.expected)
+		}
+	}
+
+	root, _ := ParseCSV([]byte(input), ',', false)
+	if age := root.Children[1].Children[1]; age.Start != (Position{Offset: 46, Line: 4, Column: 7}) {
+		t.Errorf("age start: %+v", age.Start)
+	}
+
+	tsv, err := ParseCSV([]byte("a\tb\n1\tx\"y\n"), '\t', true)
+	if err != nil || len(tsv.Children) != 1 || tsv.Children[0].Children[1].Value != `x"y` {
+		t.Errorf("ParseCSV(tsv): %v", err)
+	}
+
+	if _, err := ParseCSV([]byte("a,b\n\"x,1\n"), ',', false); err == nil {
+		t.Errorf("ParseCSV: expected error")
+	}
+}
+
+func TestParseMsgPack(t *testing.T) {
+	input := []byte("\x87" +
+		"\xa1a\x93\x01\xff\xcd\x01\x00" + // a: [1, -1, 256]
+		"\xa1b\xd0\x80" + // b: -128
+		"\xa1c\xcb\x3f\xf8\x00\x00\x00\x00\x00\x00" + // c: 1.5
+		"\xa1d\xc4\x02\xca\xfe" + // d: bin
+		"\xa1e\xd6\xff\x00\x00\x00\x3c" + // e: timestamp 32
+		"\x01\xc3" + // 1: true
+		"\xa1f\xc0") // f: nil
+
+	root, err := ParseMsgPack(input)
+	if err != nil {
+		t.Fatalf("ParseMsgPack(%x): %v", input, err)
+	}
+
+	expected := []string{
+		".a[0] number 1",
+		".a[1] number -1",
+		".a[2] number 256",
+		".b number -128",
+		".c number 1.5",
+		".d bytes cafe",
+		".e datetime 1970-01-01T00:01:00Z",
+		`.["1"] bool true`,
+		".f null null",
+	}
+	if actual := describe(root); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("ParseMsgPack(%x):\n%q\nwant:\n%q", input, actual, expected)
+	}
+
+	stream, err := ParseMsgPack([]byte("\x01\x02"))
+	if err != nil || stream.Type != Stream || len(stream.Children) != 2 {
+		t.Errorf("ParseMsgPack(stream): %v", err)
+	}
+
+	for _, input := range []string{"", "\xc1", "\x92\x01", "\xdd\xff\xff\xff\xff", "\xa3ab"} {
+		if _, err := ParseMsgPack([]byte(input)); err == nil {
+			t.Errorf("ParseMsgPack(%x): expected error", input)
+		}
+	}
+}
+
+func TestParseCBOR(t *testing.T) {
+	input := []byte(cborMagic + "\xa6" +
+		"\x61a\x83\x01\x20\x19\x01\x00" + // a: [1, -1, 256]
+		"\x61b\x9f\xf9\x3c\x00\xf5\xff" + // b: [_ 1.0, true]
+		"\x61c\x42\xca\xfe" + // c: bytes
+		"\x61d\xc1\x18\x3c" + // d: epoch 60
+		"\x61e\xc2\x49\x01\x00\x00\x00\x00\x00\x00\x00\x00" + // e: 2^64
+		"\x61f\x7f\x61x\x61y\xff") // f: (_ "x", "y")
+
+	root, err := ParseCBOR(input)
+	if err != nil {
+		t.Fatalf("ParseCBOR(%x): %v", input, err)
+	}
+
+	expected := []string{
+		".a[0] number 1",
+		".a[1] number -1",
+		".a[2] number 256",
+		".b[0] number 1",
+		".b[1] bool true",
+		".c bytes cafe",
+		".d datetime 1970-01-01T00:01:00Z",
+		".e number 18446744073709551616",
+		`.f string "xy"`,
+	}
+	if actual := describe(root); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("ParseCBOR(%x):\n%q\nwant:\n%q", input, actual, expected)
+	}
+	if !IsCBOR(input) || IsCBOR([]byte("\xd9\xd9")) {
+		t.Errorf("IsCBOR: wrong result")
+	}
+
+	for _, input := range []string{"", "\xff", "\x82\x01", "\x1c", "\x9b\xff\xff\xff\xff\xff\xff\xff\xff", "\x5f\x61a\xff"} {
+		if _, err := ParseCBOR([]byte(input)); err == nil {
+			t.Errorf("ParseCBOR(%x): expected error", input)
+		}
+	}
+
+	_, err = ParseCBOR([]byte("\x82\x01"))
+	if err == nil || err.Error() != "length 2 exceeds the remaining input at offset 1" {
+		t.Errorf("ParseCBOR: %v", err)
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	input := "{\n  \"a\": 1,\n\t\"b\": }\n}\n"
+	expected := "" +
+		"1 | {\n" +
+		"2 |   \"a\": 1,\n" +
+		"3 |  \"b\": }\n" +
+		"  |       ^\n" +
+		"4 | }\n"
+
+	_, err := Parse([]byte(input))
+	if actual := Excerpt([]byte(input), err.(*SyntaxError).Pos); actual != expected {
+		t.Errorf("Excerpt(%q):\n%s\nwant:\n%s", input, actual, expected)
+	}
+
+	input = "[1,\n"
+	expected = "" +
+		"1 | [1,\n" +
+		"2 | \n" +
+		"  | ^\n"
+	_, err = Parse([]byte(input))
+	if actual := Excerpt([]byte(input), err.(*SyntaxError).Pos); actual != expected {
+		t.Errorf("Excerpt(%q):\n%s\nwant:\n%s", input, actual, expected)
+	}
+
+	long := "[" + strings.Repeat("1,", 100) + "x]"
+	expected = "" +
+		"1 | …" + strings.Repeat("1,", 18) + "x]\n" +
+		"  |  " + strings.Repeat(" ", 36) + "^\n"
+	if actual := Excerpt([]byte(long), Position{Offset: 201, Line: 1, Column: 202}); actual != expected {
+		t.Errorf("Excerpt(long):\n%s\nwant:\n%s", actual, expected)
+	}
+
+	if actual := Excerpt([]byte("\x01"), Position{Offset: 1}); actual != "" {
+		t.Errorf("Excerpt(binary): %q", actual)
+	}
+}
+
+// sameTree reports a difference between the indexed value n and the parsed
+// value m.
+func sameTree(t *testing.T, n, m *Node) {
+	t.Helper()
+	if n.Type != m.Type || n.Key != m.Key || n.Literal != m.Literal || n.Value != m.Value || n.Index != m.Index ||
+		n.Path() != m.Path() || n.Start.Offset != m.Start.Offset || n.End.Offset != m.End.Offset || n.Len() != m.Len() {
+		t.Fatalf("indexed %v %s %q (%d children), parsed %v %s %q (%d children)",
+			n.Type, n.Path(), n.Literal, n.Len(), m.Type, m.Path(), m.Literal, m.Len())
+	}
+	i := 0
+	n.Range(0, func(child *Node) bool {
+		if child.Parent != n {
+			t.Errorf("%s: parent of child %d not set", n.Path(), i)
+		}
+		sameTree(t, child, m.Children[i])
+		i++
+		return true
+	})
+	if n.Len() > 0 {
+		sameTree(t, n.Child(n.Len()-1), m.Children[n.Len()-1])
+	}
+}
+
+func TestIndexJSON(t *testing.T) {
+	members := make([]string, 3*markInterval)
+	for i := range members {
+		members[i] = fmt.Sprintf(`"k%d": [%d, {"a": "x\ny"}, [], -1.5e3]`, i, i)
+	}
+	inputs := []string{
+		`{"b": [1, {"c": [], "d": [true, null, false]}], "a": {"e": "x"}, "f": 2}`,
+		"[[[]], {}, \"\\u00e4\", 0, [[1], [2, [3]]]]",
+		"{" + strings.Join(members, ", ") + "}",
+		`"scalar"`,
+	}
+	for _, input := range inputs {
+		root, err := IndexJSON([]byte(input), nil)
+		if err != nil {
+			t.Fatalf("IndexJSON(%.40q): %v", input, err)
+		}
+		parsed, _ := Parse([]byte(input))
+		sameTree(t, root, parsed)
+	}
+
+	for _, tt := range invalidExamples {
+		_, err := IndexJSON([]byte(tt.input), nil)
+		if syntaxErr, ok := err.(*SyntaxError); !ok || syntaxErr.Pos != tt.pos {
+			t.Errorf("IndexJSON(%v): %v, want syntax error at %+v", tt.input, err, tt.pos)
+		}
+	}
+}
+
+func TestIndexStream(t *testing.T) {
+	input := "{\"a\": [1]}\n\n{\"a\": tru}\n[] [2]\r\n"
+	stream, err := IndexStream([]byte(input), nil)
+	if err != nil {
+		t.Fatalf("IndexStream(%q): %v", input, err)
+	}
+	sameTree(t, stream, ParseStream([]byte(input)))
+	if record := stream.Child(1); record.Err == nil || record.Literal != `{"a": tru}` {
+		t.Errorf("invalid record: %q, %v", record.Literal, record.Err)
+	}
+
+	// Records are not separated by commas, so commas are invalid records.
+	for _, invalid := range []string{"1\n,\n2\n", "{\"a\": [1, \"x\nnull]", `1, /2.5e3, "x", true,`, "[1,\n[2]] {\"b\": [3]}\n4"} {
+		stream, _ := IndexStream([]byte(invalid), nil)
+		sameTree(t, stream, ParseStream([]byte(invalid)))
+	}
+
+	appended := "5\n{\"b\": {\"c\": true}}\n"
+	records := Append(stream, []byte(appended))
+	if len(records) != 2 || records[1].Record() != 6 {
+		t.Fatalf("Append(%q): %d records", appended, len(records))
+	}
+	sameTree(t, stream, ParseStream([]byte(input+appended)))
+}
+
+func TestIndexProgress(t *testing.T) {
+	input := "[" + strings.Repeat(`{"a": [1, 2]}, `, 3*progressInterval) + "0]"
+	var calls int
+	root, err := IndexJSON([]byte(input), func(offset int, top *Node) bool {
+		calls++
+		partial := Partial(top)
+		n := partial.Len()
+		if n == 0 || n > top.Len() {
+			t.Fatalf("Progress(%d): partial has %d of %d children", offset, n, top.Len())
+		}
+		last := partial.Child(n - 1)
+		if last.Type != Object || last.Child(0).Len() != 2 {
+			t.Errorf("Progress(%d): last child of the partial value %v", offset, last.Type)
+		}
+		return true
+	})
+	if err != nil || root.Len() != 3*progressInterval+1 || calls == 0 {
+		t.Fatalf("IndexJSON: %v, %d children, %d calls", err, root.Len(), calls)
+	}
+	if _, err := IndexJSON([]byte(input), func(int, *Node) bool { return false }); err != ErrCanceled {
+		t.Errorf("IndexJSON canceled: %v, want %v", err, ErrCanceled)
+	}
+}
+
+func TestIndexDuplicates(t *testing.T) {
+	input := `{"a": 1, "b": {"x": 1, "y": 2, "x": 3}, "a": [{"k": 1, "k": 2}], "c": {"a": 1}, "a": 4}`
+	root, _ := IndexJSON([]byte(input), nil)
+
+	var paths []string
+	for _, n := range FindDuplicates(root) {
+		paths = append(paths, n.Path())
+	}
+	if expected := []string{".", ".b", ".a[0]"}; !reflect.DeepEqual(paths, expected) {
+		t.Errorf("FindDuplicates(%q): %q, want %q", input, paths, expected)
+	}
+	if a, b := root.Child(0), root.Child(1); !a.Duplicate || b.Duplicate || !b.Child(2).Duplicate {
+		t.Errorf("FindDuplicates(%q): duplicates not marked", input)
+	}
+	if !root.Child(1).Same(root.Child(1)) || root.Child(1).Same(root.Child(2)) {
+		t.Errorf("Same: decoded nodes not compared by position")
+	}
+}
+
+func TestLayout(t *testing.T) {
+	input := `{"b": [1, {"c": [], "d": [true, null]}], "a": {"e": "x"}, "f": 2}`
+	root, _ := IndexJSON([]byte(input), nil)
+	// {
+	//   "b": [
+	//     1,
+	//     {
+	//       "c": [],
+	//       "d": [
+	//         true,
+	//         null
+	//       ]
+	//     }
+	//   ],
+	//   "a": {
+	//     "e": "x"
+	//   },
+	//   "f": 2
+	// }
+	expected := []string{".", ".b", ".b[0]", ".b[1]", ".b[1].c", ".b[1].d", ".b[1].d[0]", ".b[1].d[1]",
+		".b[1].d", ".b[1]", ".b", ".a", ".a.e", ".a", ".f", "."}
+	layout := NewLayout(root, false, false)
+	if layout.Lines() != len(expected) {
+		t.Fatalf("Lines: %d, want %d", layout.Lines(), len(expected))
+	}
+
+	for ln, path := range expected {
+		n := layout.NodeAt(ln)
+		if n.Path() != path {
+			t.Errorf("NodeAt(%d): %s, want %s", ln, n.Path(), path)
+			continue
+		}
+		first, _ := layout.LineOf(n, false)
+		last, _ := layout.LineOf(n, true)
+		if ln != first && ln != last || expected[first] != path || expected[last] != path {
+			t.Errorf("LineOf(%s): %d, %d", path, first, last)
+		}
+	}
+
+	if count, ok := layout.Children(1); count != 2 || !ok || layout.ChildLine(1, 1) != 3 || layout.ChildAt(1, 7) != 1 {
+		t.Errorf("children of .b: %d %v, second on line %d", count, ok, layout.ChildLine(1, 1))
+	}
+	if count, ok := layout.Children(-1); count != 3 || !ok || layout.ChildAt(-1, 14) != 2 {
+		t.Errorf("children of .: %d %v", count, ok)
+	}
+	if _, ok := layout.Children(2); ok {
+		t.Errorf("children of .b[0]: no container starts on line 2")
+	}
+
+	var segments []string
+	layout.Segments(2, 13, func(start, end, depth int) bool {
+		segments = append(segments, fmt.Sprintf("%s %d-%d %d", expected[start], start, end, depth))
+		return true
+	})
+	if want := []string{".b[1] 3-9 2", ".a 11-13 1"}; !reflect.DeepEqual(segments, want) {
+		t.Errorf("Segments: %q, want %q", segments, want)
+	}
+}
@@ -0,0 +1,355 @@
+package jsonast
+
+import "sort"
+
+// Layout assigns lines to the values of an indexed document the way a
+// formatter writes it: every value starts a line of its own and the last
+// line of a container with children closes it, see Indexed. Layouts that
+// differ from that, e.g. because containers are written on one line, are
+// recorded with Record and RecordChild while the whole document is written.
+type Layout struct {
+	x      *index
+	sorted bool
+
+	// recorded is set for layouts recorded with Record, whose lines line
+	// and lines hold by span, and marks the lines of every markInterval-th
+	// child written.
+	recorded    bool
+	line, lines []int
+	marks       map[int32][]int
+
+	// orders holds the members of objects in the order written if they
+	// are sorted by key.
+	orders map[int32][]entry
+}
+
+// NewLayout returns the layout of the indexed document n is part of. If
+// sorted is set, object members are written ordered by key. If recorded is
+// set, the lines of the containers are not derived from the index but set
+// with Record and RecordChild.
+func NewLayout(n *Node, sorted, recorded bool) *Layout {
+	l := &Layout{x: n.index, sorted: sorted, recorded: recorded, orders: map[int32][]entry{}}
+	if recorded {
+		l.marks = map[int32][]int{}
+	}
+	return l
+}
+
+// Record sets the lines of the indexed container or stream n, which starts
+// on line and takes lines lines.
+func (l *Layout) Record(n *Node, line, lines int) {
+	for len(l.line) < len(l.x.spans) {
+		l.line, l.lines = append(l.line, 0), append(l.lines, 0)
+	}
+	l.line[n.span], l.lines[n.span] = line, lines
+}
+
+// RecordChild sets the line the child of the indexed container or stream n
+// at position k in the order written starts on.
+func (l *Layout) RecordChild(n *Node, k, line int) {
+	if k == 0 || k%markInterval != 0 {
+		return
+	}
+	marks := l.marks[n.span]
+	if i := k/markInterval - 1; i < len(marks) {
+		marks[i] = line
+	} else {
+		l.marks[n.span] = append(marks, line)
+	}
+}
+
+// Lines returns the number of lines of the document.
+func (l *Layout) Lines() int {
+	return max(1, l.spanLines(0))
+}
+
+// Range calls fn for the children of the indexed container or stream n in
+// the order written, from position k on, until fn returns false.
+func (l *Layout) Range(n *Node, k int, fn func(child *Node) bool) {
+	l.entries(n.span, k, func(_ int, e entry) bool { return fn(l.x.decode(n, e)) })
+}
+
+// Skip returns the position of the first child of the indexed container or
+// stream n that ends on or after line ln and the line it starts on, or the
+// number of children and the line after them if there is none.
+func (l *Layout) Skip(n *Node, ln int) (int, int) {
+	k, line, _, _ := l.child(n.span, ln)
+	return k, line
+}
+
+// Children returns the number of children of the container starting on
+// line ln, or of the root for -1. ok is false if no container starts there.
+func (l *Layout) Children(ln int) (count int, ok bool) {
+	s, ok := l.spanAt(ln)
+	if !ok {
+		return 0, false
+	}
+	return int(l.x.spans[s].count), true
+}
+
+// ChildLine returns the line the child at position k in the order written
+// of the container starting on line ln, or of the root for -1, starts on.
+func (l *Layout) ChildLine(ln, k int) int {
+	s, _ := l.spanAt(ln)
+	return l.childLine(s, k)
+}
+
+// ChildAt returns the position in the order written of the child of the
+// container starting on line ln, or of the root for -1, that holds line
+// line.
+func (l *Layout) ChildAt(ln, line int) int {
+	s, _ := l.spanAt(ln)
+	k, _, _, _ := l.child(s, line)
+	return k
+}
+
+// NodeAt returns the innermost value shown on line ln, which for the first
+// and last line of a container is the container. It is nil for lines of a
+// stream that hold no record.
+func (l *Layout) NodeAt(ln int) *Node {
+	n := l.x.root
+	for {
+		start, lines := l.spanLine(n.span), l.spanLines(n.span)
+		if n.Type != Stream && (ln <= start || ln >= start+lines-1) {
+			return n
+		}
+		_, _, e, ok := l.child(n.span, ln)
+		if !ok {
+			return nil
+		}
+		child := l.x.decode(n, e)
+		if e.span < 0 {
+			return child
+		}
+		n = child
+	}
+}
+
+// LineOf returns the first line of the value n, or the last one if last is
+// set. Values written on the line of their container return that line. ok
+// is false if n is not part of the document.
+func (l *Layout) LineOf(n *Node, last bool) (line int, ok bool) {
+	if n.index != l.x {
+		return 0, false
+	}
+	if n.span >= 0 {
+		line = l.spanLine(n.span)
+		if last {
+			line += max(1, l.spanLines(n.span)) - 1
+		}
+		return line, true
+	}
+
+	ps := n.Parent.span
+	if n.Parent.Type != Stream && l.spanLines(ps) == 1 {
+		return l.spanLine(ps), true
+	}
+	k := n.Index
+	if l.isSorted(ps) {
+		for i, e := range l.order(ps) {
+			if e.index == n.Index {
+				k = i
+			}
+		}
+	}
+	return l.childLine(ps, k), true
+}
+
+// Segments calls fn with the first and last line and the depth of the
+// outermost containers taking several lines that start on the lines from up
+// to to, in order, until fn returns false.
+func (l *Layout) Segments(from, to int, fn func(start, end, depth int) bool) {
+	l.segments(0, from, to, fn)
+}
+
+func (l *Layout) segments(s int32, from, to int, fn func(start, end, depth int) bool) bool {
+	if !l.x.stream || s != 0 {
+		start, lines := l.spanLine(s), l.spanLines(s)
+		switch {
+		case start >= to || start+lines <= from || lines == 1:
+			return true
+		case start >= from:
+			return fn(start, start+lines-1, int(l.x.spans[s].depth))
+		}
+	}
+
+	// The container starts before from, so it is the containers within it
+	// that are looked for.
+	if l.isSorted(s) {
+		for _, e := range l.order(s) {
+			if e.span >= 0 && !l.segments(e.span, from, to, fn) {
+				return false
+			}
+		}
+		return true
+	}
+
+	c, next := s+1, l.x.spans[s].next
+	if !l.sorted {
+		// Spans start in the order of their lines, so the last one
+		// starting before from belongs to the first child of s to look at.
+		i := sort.Search(int(next-c), func(i int) bool { return l.spanLine(c+int32(i)) >= from })
+		if i > 0 {
+			for c += int32(i) - 1; l.x.spans[c].parent != s; c = l.x.spans[c].parent {
+			}
+		}
+	}
+	for ; c < next && l.spanLine(c) < to; c = l.x.spans[c].next {
+		if !l.segments(c, from, to, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// spanAt returns the outermost container starting on line ln, or the root
+// for -1. Spans start in the order of their lines unless keys are sorted, so
+// they are searched without decoding anything then.
+func (l *Layout) spanAt(ln int) (int32, bool) {
+	if ln < 0 {
+		return 0, true
+	}
+	if l.sorted {
+		if n := l.NodeAt(ln); n != nil && n.span >= 0 && l.spanLine(n.span) == ln {
+			return n.span, true
+		}
+		return 0, false
+	}
+
+	first := 0
+	if l.x.stream {
+		first = 1
+	}
+	i := first + sort.Search(len(l.x.spans)-first, func(i int) bool { return l.spanLine(int32(first+i)) >= ln })
+	return int32(i), i < len(l.x.spans) && l.spanLine(int32(i)) == ln
+}
+
+// spanLine returns the first line of the span s.
+func (l *Layout) spanLine(s int32) int {
+	if l.recorded {
+		return l.line[s]
+	}
+	return l.x.spans[s].line
+}
+
+// spanLines returns the number of lines of the span s.
+func (l *Layout) spanLines(s int32) int {
+	if l.recorded {
+		return l.lines[s]
+	}
+	return l.x.spans[s].lines
+}
+
+// entryLines returns the number of lines of the child e.
+func (l *Layout) entryLines(e entry) int {
+	if e.span < 0 {
+		return 1
+	}
+	return l.spanLines(e.span)
+}
+
+// isSorted reports whether the children of s are written in another order
+// than in the source.
+func (l *Layout) isSorted(s int32) bool {
+	return l.sorted && (!l.x.stream || s != 0) && l.x.data[l.x.spans[s].start] == '{'
+}
+
+// order returns the members of the object s sorted by key.
+func (l *Layout) order(s int32) []entry {
+	if order, ok := l.orders[s]; ok {
+		return order
+	}
+
+	type member struct {
+		entry
+		key string
+	}
+	var members []member
+	l.x.scan(s, 0, func(e entry) bool {
+		p := &parser{data: l.x.data, pos: Position{Offset: e.offset}}
+		_, key, _ := p.parseString()
+		members = append(members, member{e, key})
+		return true
+	})
+	sort.SliceStable(members, func(i, j int) bool { return members[i].key < members[j].key })
+
+	order := make([]entry, len(members))
+	for i, m := range members {
+		order[i] = m.entry
+	}
+	l.orders[s] = order
+	return order
+}
+
+// entries calls fn with the position and the children of s in the order
+// written, from position k on, until fn returns false.
+func (l *Layout) entries(s int32, k int, fn func(k int, e entry) bool) {
+	if l.isSorted(s) {
+		order := l.order(s)
+		for ; k < len(order); k++ {
+			if !fn(k, order[k]) {
+				return
+			}
+		}
+		return
+	}
+	l.x.scan(s, k, func(e entry) bool { return fn(e.index, e) })
+}
+
+// firstChild returns the line the first child of s starts on.
+func (l *Layout) firstChild(s int32) int {
+	if l.x.stream && s == 0 {
+		return 0
+	}
+	return l.spanLine(s) + 1
+}
+
+// mark returns the position and line of the child of s with the last mark
+// for which before returns true, or the first child.
+func (l *Layout) mark(s int32, before func(k, line int) bool) (int, int) {
+	k, line := 0, l.firstChild(s)
+	switch {
+	case l.recorded:
+		marks := l.marks[s]
+		i := sort.Search(len(marks), func(i int) bool { return !before((i+1)*markInterval, marks[i]) })
+		if i > 0 {
+			k, line = i*markInterval, marks[i-1]
+		}
+	case !l.isSorted(s):
+		marks := l.x.marks[s]
+		i := sort.Search(len(marks), func(i int) bool { return !before((i+1)*markInterval, marks[i].line) })
+		if i > 0 {
+			k, line = i*markInterval, marks[i-1].line
+		}
+	}
+	return k, line
+}
+
+// child returns the child of s that ends on or after line ln with its
+// position and first line. If there is none, ok is false and k and line
+// are the number of children and the line after them.
+func (l *Layout) child(s int32, ln int) (k, line int, e entry, ok bool) {
+	k, line = l.mark(s, func(_, line int) bool { return line <= ln })
+	l.entries(s, k, func(i int, c entry) bool {
+		if line+l.entryLines(c) > ln {
+			k, e, ok = i, c, true
+			return false
+		}
+		k, line = i+1, line+l.entryLines(c)
+		return true
+	})
+	return k, line, e, ok
+}
+
+// childLine returns the line the child of s at position k starts on.
+func (l *Layout) childLine(s int32, k int) int {
+	i, line := l.mark(s, func(i, _ int) bool { return i <= k })
+	l.entries(s, i, func(i int, e entry) bool {
+		if i == k {
+			return false
+		}
+		line += l.entryLines(e)
+		return true
+	})
+	return line
+}
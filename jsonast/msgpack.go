@@ -0,0 +1,198 @@
+package jsonast
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+// ParseMsgPack decodes MessagePack data. Several concatenated values are
+// returned as a Stream node. Binary data and extension types become Bytes
+// nodes, except for timestamps, which become DateTime nodes.
+func ParseMsgPack(data []byte) (*Node, error) {
+	d := &msgpackDecoder{binaryDecoder{data: data}}
+	return d.decodeAll(d.decode)
+}
+
+type msgpackDecoder struct {
+	binaryDecoder
+}
+
+func (d *msgpackDecoder) decode(parent *Node, index int) (*Node, error) {
+	n := &Node{Parent: parent, Index: index, Start: d.pos()}
+	c, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case c <= 0x7f:
+		n.Type, n.Literal = Number, strconv.Itoa(int(c))
+	case c >= 0xe0:
+		n.Type, n.Literal = Number, strconv.Itoa(int(int8(c)))
+	case c <= 0x8f:
+		err = d.decodeMap(n, uint64(c&0x0f))
+	case c <= 0x9f:
+		err = d.decodeArray(n, uint64(c&0x0f))
+	case c <= 0xbf:
+		err = d.decodeString(n, uint64(c&0x1f))
+	case c == 0xc0:
+		n.Type, n.Literal = Null, "null"
+	case c == 0xc2:
+		n.Type, n.Literal = Bool, "false"
+	case c == 0xc3:
+		n.Type, n.Literal = Bool, "true"
+	case 0xc4 <= c && c <= 0xc6:
+		var size uint64
+		if size, err = d.readUint(1 << (c - 0xc4)); err == nil {
+			var b []byte
+			if b, err = d.read(size); err == nil {
+				setBytes(n, b)
+			}
+		}
+	case 0xc7 <= c && c <= 0xc9:
+		var size uint64
+		if size, err = d.readUint(1 << (c - 0xc7)); err == nil {
+			err = d.decodeExt(n, size)
+		}
+	case c == 0xca:
+		var bits uint64
+		if bits, err = d.readUint(4); err == nil {
+			setFloat(n, float64(math.Float32frombits(uint32(bits))), 32)
+		}
+	case c == 0xcb:
+		var bits uint64
+		if bits, err = d.readUint(8); err == nil {
+			setFloat(n, math.Float64frombits(bits), 64)
+		}
+	case 0xcc <= c && c <= 0xcf:
+		var u uint64
+		if u, err = d.readUint(1 << (c - 0xcc)); err == nil {
+			n.Type, n.Literal = Number, strconv.FormatUint(u, 10)
+		}
+	case 0xd0 <= c && c <= 0xd3:
+		size := 1 << (c - 0xd0)
+		var u uint64
+		if u, err = d.readUint(size); err == nil {
+			// Sign-extend the value to 64 bits.
+			shift := uint(64 - 8*size)
+			n.Type, n.Literal = Number, strconv.FormatInt(int64(u<<shift)>>shift, 10)
+		}
+	case 0xd4 <= c && c <= 0xd8:
+		err = d.decodeExt(n, 1<<(c-0xd4))
+	case 0xd9 <= c && c <= 0xdb:
+		var size uint64
+		if size, err = d.readUint(1 << (c - 0xd9)); err == nil {
+			err = d.decodeString(n, size)
+		}
+	case c == 0xdc || c == 0xdd:
+		var count uint64
+		if count, err = d.readUint(2 << (c - 0xdc)); err == nil {
+			err = d.decodeArray(n, count)
+		}
+	case c == 0xde || c == 0xdf:
+		var count uint64
+		if count, err = d.readUint(2 << (c - 0xde)); err == nil {
+			err = d.decodeMap(n, count)
+		}
+	default:
+		d.off--
+		err = d.errorf("invalid MessagePack type 0x%02x", c)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	n.End = d.pos()
+	return n, nil
+}
+
+func (d *msgpackDecoder) decodeString(n *Node, size uint64) error {
+	b, err := d.read(size)
+	if err != nil {
+		return err
+	}
+	setString(n, string(b))
+	return nil
+}
+
+func (d *msgpackDecoder) decodeArray(n *Node, count uint64) error {
+	n.Type = Array
+	if err := d.checkCount(count); err != nil {
+		return err
+	}
+
+	for i := uint64(0); i < count; i++ {
+		child, err := d.decode(n, int(i))
+		if err != nil {
+			return err
+		}
+		n.Children = append(n.Children, child)
+	}
+	return nil
+}
+
+func (d *msgpackDecoder) decodeMap(n *Node, count uint64) error {
+	n.Type = Object
+	if err := d.checkCount(count); err != nil {
+		return err
+	}
+
+	for i := uint64(0); i < count; i++ {
+		key, err := d.decode(n, int(i))
+		if err != nil {
+			return err
+		}
+		if key.IsContainer() {
+			return &SyntaxError{Msg: "map keys must be scalars", Pos: key.Start}
+		}
+
+		child, err := d.decode(n, int(i))
+		if err != nil {
+			return err
+		}
+		child.Key = keyText(key)
+		child.KeyLiteral = Quote(child.Key)
+		n.Children = append(n.Children, child)
+	}
+	return nil
+}
+
+// decodeExt reads the type and data of an extension. Type -1 is the
+// timestamp extension.
+func (d *msgpackDecoder) decodeExt(n *Node, size uint64) error {
+	typ, err := d.readByte()
+	if err != nil {
+		return err
+	}
+	b, err := d.read(size)
+	if err != nil {
+		return err
+	}
+
+	if int8(typ) != -1 {
+		setBytes(n, b)
+		return nil
+	}
+
+	var sec int64
+	var nsec uint32
+	switch size {
+	case 4:
+		sec = int64(uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3]))
+	case 8:
+		v := uint64(b[0])<<56 | uint64(b[1])<<48 | uint64(b[2])<<40 | uint64(b[3])<<32 |
+			uint64(b[4])<<24 | uint64(b[5])<<16 | uint64(b[6])<<8 | uint64(b[7])
+		nsec, sec = uint32(v>>34), int64(v&(1<<34-1))
+	case 12:
+		nsec = uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+		for _, c := range b[4:] {
+			sec = sec<<8 | int64(c)
+		}
+	default:
+		return &SyntaxError{Msg: "invalid timestamp length " + strconv.FormatUint(size, 10), Pos: n.Start}
+	}
+
+	setTime(n, time.Unix(sec, int64(nsec)))
+	return nil
+}
@@ -0,0 +1,87 @@
+package jsonast
+
+import (
+	"math/big"
+	"strings"
+)
+
+// setNumber makes n a Number written as text in the source, which may use
+// the notations of YAML, TOML or JSON5 such as 0x1F, 1_000, +5, .5 or .inf.
+// Literal is set to the number in JSON notation and Source to text if the
+// two differ.
+func setNumber(n *Node, text string) {
+	n.Type, n.Literal, n.Value, n.Source = Number, numberLiteral(text), "", ""
+	if n.Literal != text {
+		n.Source = text
+	}
+}
+
+// numberLiteral converts a number in the notation of YAML, TOML or JSON5
+// to JSON notation. Infinities and NaN become Infinity, -Infinity and NaN,
+// which JSON has no notation for. text is returned as is if it is no number.
+func numberLiteral(text string) string {
+	s := strings.Replace(text, "_", "", -1)
+
+	sign := ""
+	switch {
+	case strings.HasPrefix(s, "-"):
+		sign, s = "-", s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+
+	switch strings.ToLower(strings.TrimPrefix(s, ".")) {
+	case "inf", "infinity":
+		return sign + "Infinity"
+	case "nan":
+		return "NaN"
+	}
+
+	if len(s) > 2 && s[0] == '0' {
+		base := 0
+		switch s[1] {
+		case 'x', 'X':
+			base = 16
+		case 'o', 'O':
+			base = 8
+		case 'b', 'B':
+			base = 2
+		}
+		if base != 0 {
+			i, ok := new(big.Int).SetString(s[2:], base)
+			if !ok {
+				return text
+			}
+			if i.Sign() == 0 {
+				sign = ""
+			}
+			return sign + i.String()
+		}
+	}
+
+	mantissa, exponent := s, ""
+	if e := strings.IndexAny(s, "eE"); e >= 0 {
+		mantissa, exponent = s[:e], s[e:]
+	}
+	integer, fraction := mantissa, ""
+	if dot := strings.IndexByte(mantissa, '.'); dot >= 0 {
+		integer, fraction = mantissa[:dot], mantissa[dot+1:]
+		if fraction == "" {
+			fraction = "0"
+		}
+	}
+	integer = strings.TrimLeft(integer, "0")
+	if integer == "" {
+		integer = "0"
+	}
+
+	literal := sign + integer
+	if fraction != "" {
+		literal += "." + fraction
+	}
+	literal += exponent
+	if !jsonNumber.MatchString(literal) {
+		return text
+	}
+	return literal
+}
@@ -0,0 +1,904 @@
+package jsonast
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+type SyntaxError struct {
+	Msg string
+	Pos Position
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Pos.Line == 0 {
+		return fmt.Sprintf("%s at offset %d", e.Msg, e.Pos.Offset)
+	}
+	return fmt.Sprintf("%s at line %d, column %d", e.Msg, e.Pos.Line, e.Pos.Column)
+}
+
+type parser struct {
+	data []byte
+	pos  Position
+
+	// lenient enables the JSONC and JSON5 extensions, see ParseLenient.
+	lenient bool
+
+	// recovering turns syntax errors into problems, see ParseRecover.
+	recovering bool
+	problems   []*Node
+
+	// comments holds the comments read since they were last attached to
+	// a node.
+	comments []comment
+
+	// progress is called for every progressInterval values, see Progress.
+	// top is the top-level value, values the number of values parsed.
+	progress Progress
+	top      *Node
+	values   int
+}
+
+type comment struct {
+	text string
+	line int
+}
+
+func newParser(data []byte) *parser {
+	return &parser{data: data, pos: Position{Line: 1, Column: 1}}
+}
+
+// Parse parses a single JSON value. Any content besides whitespace after the
+// value is an error.
+func Parse(data []byte) (*Node, error) {
+	return newParser(data).parseDocument()
+}
+
+// ParseLenient parses a single value of JSONC or JSON5. Besides JSON it
+// accepts comments, trailing commas, unquoted keys, single quoted strings,
+// hexadecimal numbers, Infinity and NaN. Comments are kept on the nodes.
+func ParseLenient(data []byte) (*Node, error) {
+	p := newParser(data)
+	p.lenient = true
+	return p.parseDocument()
+}
+
+// ParseStream parses a sequence of JSON values, such as JSON Lines or
+// concatenated JSON, into a Stream node. A value that fails to parse is
+// kept as an Invalid node up to the end of the line it starts on, and
+// parsing resumes on the following line.
+func ParseStream(data []byte) *Node {
+	return newParser(data).parseStream()
+}
+
+func (p *parser) parseStream() *Node {
+	stream := &Node{Type: Stream, Start: p.pos}
+	p.top = stream
+
+	for {
+		p.skipWhitespace()
+		if _, ok := p.peek(); !ok {
+			break
+		}
+
+		start := p.pos
+		n, err := p.parseValue(stream, len(stream.Children))
+		if err != nil {
+			n = p.invalidRecord(start, err)
+			n.Parent, n.Index = stream, len(stream.Children)
+		}
+		stream.Children = append(stream.Children, n)
+	}
+
+	stream.End = p.pos
+	return stream
+}
+
+// invalidRecord returns the record starting at start, which failed to parse
+// with err, as an Invalid node. The record ends with its first line; a
+// value that is not closed there must not swallow the records that follow.
+func (p *parser) invalidRecord(start Position, err error) *Node {
+	p.pos = start
+	for c, ok := p.peek(); ok && c != '\n'; c, ok = p.peek() {
+		p.advance()
+	}
+	if err, ok := err.(*SyntaxError); ok && err.Pos.Offset > p.pos.Offset {
+		err.Msg, err.Pos = "unexpected end of line", p.pos
+	}
+	p.skipLine()
+	return &Node{
+		Type:    Invalid,
+		Literal: strings.TrimRight(string(p.data[start.Offset:p.pos.Offset]), "\r\n"),
+		Err:     err,
+		Start:   start,
+		End:     p.pos,
+	}
+}
+
+// ParseRecover parses JSON, JSONC or JSON5 as far as possible instead of
+// failing at the first syntax error. Invalid values become Invalid nodes up
+// to the next comma or bracket, containers that are not closed properly are
+// closed automatically and get their Err set, and further top-level values
+// are returned as records of a Stream node. The nodes with problems are
+// returned in the order they were found.
+func ParseRecover(data []byte) (*Node, []*Node) {
+	p := newParser(data)
+	p.lenient, p.recovering = true, true
+	stream := &Node{Type: Stream, Start: p.pos}
+
+	for {
+		p.skipWhitespace()
+		if _, ok := p.peek(); !ok {
+			break
+		}
+
+		comments := p.takeComments()
+		n, _ := p.parseValue(stream, len(stream.Children))
+		n.Comments = comments
+		p.skipWhitespace()
+		n.LineComment = p.takeLineComment(n.End.Line)
+		stream.Children = append(stream.Children, n)
+	}
+	stream.End = p.pos
+
+	if len(stream.Children) != 1 {
+		if len(stream.Children) == 0 {
+			p.problem(stream, p.errorf("unexpected end of JSON input"))
+			return &Node{Type: Invalid, Err: stream.Err}, p.problems
+		}
+		return stream, p.problems
+	}
+
+	root := stream.Children[0]
+	root.Parent = nil
+	root.TrailingComments = p.takeComments()
+	return root, p.problems
+}
+
+func (p *parser) parseDocument() (*Node, error) {
+	p.skipWhitespace()
+	comments := p.takeComments()
+	n, err := p.parseValue(nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	n.Comments = comments
+
+	p.skipWhitespace()
+	if c, ok := p.peek(); ok {
+		return nil, p.errorf("invalid character %s after top-level value", quoteChar(c))
+	}
+	n.LineComment = p.takeLineComment(n.End.Line)
+	n.TrailingComments = p.takeComments()
+
+	return n, nil
+}
+
+func (p *parser) parseValue(parent *Node, index int) (*Node, error) {
+	c, ok := p.peek()
+	if !ok && !p.recovering {
+		return nil, p.errorf("unexpected end of JSON input")
+	}
+
+	if p.progress != nil {
+		if err := p.reportProgress(); err != nil {
+			return nil, err
+		}
+	}
+
+	n := &Node{Parent: parent, Index: index, Start: p.pos}
+	if parent == nil {
+		p.top = n
+	}
+	var err error
+	switch {
+	case !ok:
+		err = p.errorf("unexpected end of JSON input")
+	case c == '{':
+		n.Type = Object
+		err = p.parseObject(n)
+	case c == '[':
+		n.Type = Array
+		err = p.parseArray(n)
+	case c == '"' || p.lenient && c == '\'':
+		n.Type = String
+		n.Literal, n.Value, err = p.parseString()
+	case c == '-' || isDigit(c):
+		n.Type = Number
+		n.Literal, err = p.parseNumber()
+	case p.lenient && (c == '+' || c == '.' || c == 'I' || c == 'N'):
+		n.Type = Number
+		n.Literal, err = p.parseNumber()
+	case c == 't':
+		n.Type = Bool
+		n.Literal, err = p.parseKeyword("true")
+	case c == 'f':
+		n.Type = Bool
+		n.Literal, err = p.parseKeyword("false")
+	case c == 'n':
+		n.Type = Null
+		n.Literal, err = p.parseKeyword("null")
+	default:
+		err = p.errorf("invalid character %s looking for beginning of value", quoteChar(c))
+	}
+	if err != nil && p.recovering && c != '{' && c != '[' {
+		p.recoverValue(n, err)
+	} else if err != nil {
+		return nil, err
+	}
+
+	n.End = p.pos
+	return n, nil
+}
+
+// recoverValue turns n into an Invalid node holding the source from its
+// start up to the next comma or closing bracket, or within a stream up to
+// the end of the line.
+func (p *parser) recoverValue(n *Node, err error) {
+	p.pos = n.Start
+	p.problem(n, err)
+	n.Type = Invalid
+
+	topLevel := n.Parent == nil || n.Parent.Type == Stream
+	depth, inString := 0, byte(0)
+	for c, ok := p.peek(); ok; c, ok = p.peek() {
+		switch {
+		case c == '\n' && (topLevel || inString != 0):
+			inString = 0
+			if topLevel {
+				n.Literal = strings.TrimRight(string(p.data[n.Start.Offset:p.pos.Offset]), " \t\r")
+				return
+			}
+		case inString != 0:
+			if c == '\\' {
+				p.advance()
+			} else if c == inString {
+				inString = 0
+			}
+		case c == '"' || c == '\'':
+			inString = c
+		case c == '{' || c == '[':
+			depth++
+		case (c == '}' || c == ']' || c == ',') && depth == 0 && !topLevel:
+			n.Literal = strings.TrimRight(string(p.data[n.Start.Offset:p.pos.Offset]), " \t\r\n")
+			return
+		case c == '}' || c == ']':
+			depth = max(0, depth-1)
+		}
+		p.advanceRune()
+	}
+
+	n.Literal = strings.TrimRight(string(p.data[n.Start.Offset:]), " \t\r\n")
+}
+
+// problem records err for n unless n already has an error. An error at
+// the same position as the previous one, e.g. the end of input closing
+// several containers, is only listed once.
+func (p *parser) problem(n *Node, err error) {
+	if n.Err != nil {
+		return
+	}
+	n.Err = err
+	if last := len(p.problems) - 1; last >= 0 {
+		if p.problems[last].Err.(*SyntaxError).Pos == err.(*SyntaxError).Pos {
+			return
+		}
+	}
+	p.problems = append(p.problems, n)
+}
+
+func (p *parser) parseObject(n *Node) error {
+	p.advance()
+	p.skipWhitespace()
+	if c, ok := p.peek(); ok && c == '}' {
+		n.EndComments = p.takeComments()
+		p.advance()
+		return nil
+	}
+
+	for {
+		if p.recovering && p.atEnd(n) {
+			return nil
+		}
+
+		comments := p.takeComments()
+		start := p.pos
+		keyLiteral, key, err := p.parseKey()
+		if err == nil {
+			p.skipWhitespace()
+			start = p.pos
+			err = p.expect(':', "after object key")
+		}
+
+		var child *Node
+		if err != nil && p.recovering {
+			child = &Node{Parent: n, Index: len(n.Children), Start: start}
+			p.recoverValue(child, err)
+			child.End = p.pos
+		} else if err != nil {
+			return err
+		} else {
+			p.skipWhitespace()
+			if child, err = p.parseValue(n, len(n.Children)); err != nil {
+				return err
+			}
+		}
+		child.Key, child.KeyLiteral = key, keyLiteral
+		child.Comments = comments
+		n.Children = append(n.Children, child)
+
+		if done, err := p.parseSeparator(n, child, '}', "after object key:value pair"); done || err != nil {
+			return err
+		}
+	}
+}
+
+func (p *parser) parseArray(n *Node) error {
+	p.advance()
+	p.skipWhitespace()
+	if c, ok := p.peek(); ok && c == ']' {
+		n.EndComments = p.takeComments()
+		p.advance()
+		return nil
+	}
+
+	for {
+		if p.recovering && p.atEnd(n) {
+			return nil
+		}
+
+		comments := p.takeComments()
+		child, err := p.parseValue(n, len(n.Children))
+		if err != nil {
+			return err
+		}
+		child.Comments = comments
+		n.Children = append(n.Children, child)
+
+		if done, err := p.parseSeparator(n, child, ']', "after array element"); done || err != nil {
+			return err
+		}
+	}
+}
+
+// parseSeparator consumes the comma or closing bracket following child and
+// reports whether the container n is complete.
+func (p *parser) parseSeparator(n, child *Node, closing byte, context string) (bool, error) {
+	p.skipWhitespace()
+	c, ok := p.peek()
+	switch {
+	case !ok && p.recovering:
+		// The container is closed by atEnd.
+		return false, nil
+	case !ok:
+		return false, p.errorf("unexpected end of JSON input")
+	case c == ',':
+		p.advance()
+		p.skipWhitespace()
+		child.LineComment = p.takeLineComment(child.End.Line)
+		if c, ok := p.peek(); !ok || !p.lenient || c != closing {
+			return false, nil
+		}
+	case c == closing:
+		child.LineComment = p.takeLineComment(child.End.Line)
+	case p.recovering && (c == '}' || c == ']'):
+		// A mismatched bracket closes n and is left to the parent.
+		p.problem(n, p.errorf("invalid character %s %s", quoteChar(c), context))
+		return true, nil
+	case p.recovering:
+		// Continue as if the comma was missing.
+		p.problem(child, p.errorf("invalid character %s %s", quoteChar(c), context))
+		child.LineComment = p.takeLineComment(child.End.Line)
+		return false, nil
+	default:
+		return false, p.errorf("invalid character %s %s", quoteChar(c), context)
+	}
+
+	n.EndComments = p.takeComments()
+	p.advance()
+	return true, nil
+}
+
+// atEnd closes the container n if the input ended before its closing
+// bracket.
+func (p *parser) atEnd(n *Node) bool {
+	p.skipWhitespace()
+	if _, ok := p.peek(); ok {
+		return false
+	}
+
+	n.EndComments = p.takeComments()
+	p.problem(n, p.errorf("unexpected end of JSON input"))
+	return true
+}
+
+func (p *parser) parseKey() (string, string, error) {
+	c, ok := p.peek()
+	switch {
+	case !ok:
+		return "", "", p.errorf("unexpected end of JSON input")
+	case c == '"' || p.lenient && c == '\'':
+		return p.parseString()
+	case p.lenient && isIdentifierStart(p.peekRune()):
+		start := p.pos.Offset
+		for p.pos.Offset < len(p.data) && isIdentifierPart(p.peekRune()) {
+			p.advanceRune()
+		}
+		key := string(p.data[start:p.pos.Offset])
+		return key, key, nil
+	}
+
+	return "", "", p.errorf("invalid character %s looking for beginning of object key string", quoteChar(c))
+}
+
+// parseString returns the literal of the string starting at the current
+// position and its decoded value.
+func (p *parser) parseString() (string, string, error) {
+	start := p.pos.Offset
+	quote, _ := p.peek()
+	p.advance()
+
+	var decoded []byte
+	chunk := p.pos.Offset
+	for {
+		c, ok := p.peek()
+		switch {
+		case !ok:
+			return "", "", p.errorf("unexpected end of JSON input")
+		case c == quote:
+			p.advance()
+			literal := string(p.data[start:p.pos.Offset])
+			if decoded == nil {
+				return literal, literal[1 : len(literal)-1], nil
+			}
+			decoded = append(decoded, p.data[chunk:p.pos.Offset-1]...)
+			return literal, string(decoded), nil
+		case c < 0x20:
+			return "", "", p.errorf("invalid character %s in string literal", quoteChar(c))
+		case c == '\\':
+			decoded = append(decoded, p.data[chunk:p.pos.Offset]...)
+			s, err := p.parseEscape()
+			if err != nil {
+				return "", "", err
+			}
+			decoded = append(decoded, s...)
+			chunk = p.pos.Offset
+		default:
+			p.advance()
+		}
+	}
+}
+
+// skipString moves past the string starting at the current position,
+// checking it like parseString without decoding it.
+func (p *parser) skipString() error {
+	quote, _ := p.peek()
+	p.advance()
+	for {
+		c, ok := p.peek()
+		switch {
+		case !ok:
+			return p.errorf("unexpected end of JSON input")
+		case c == quote:
+			p.advance()
+			return nil
+		case c < 0x20:
+			return p.errorf("invalid character %s in string literal", quoteChar(c))
+		case c == '\\':
+			if _, err := p.parseEscape(); err != nil {
+				return err
+			}
+		default:
+			p.advance()
+		}
+	}
+}
+
+// parseEscape returns the text an escape sequence stands for.
+func (p *parser) parseEscape() (string, error) {
+	p.advance()
+	c, ok := p.peek()
+	if !ok {
+		return "", p.errorf("unexpected end of JSON input")
+	}
+
+	switch c {
+	case '"', '\\', '/':
+		p.advance()
+		return string(c), nil
+	case 'b':
+		p.advance()
+		return "\b", nil
+	case 'f':
+		p.advance()
+		return "\f", nil
+	case 'n':
+		p.advance()
+		return "\n", nil
+	case 'r':
+		p.advance()
+		return "\r", nil
+	case 't':
+		p.advance()
+		return "\t", nil
+	case 'u':
+		p.advance()
+		r, err := p.parseHex(4)
+		if err != nil {
+			return "", err
+		}
+		if !utf16.IsSurrogate(r) {
+			return string(r), nil
+		}
+
+		// A surrogate pair is only combined if the second half follows
+		// immediately, otherwise the replacement character is used.
+		if p.hasPrefix(`\u`) {
+			save := p.pos
+			p.advance()
+			p.advance()
+			if r2, err := p.parseHex(4); err == nil {
+				if combined := utf16.DecodeRune(r, r2); combined != utf8.RuneError {
+					return string(combined), nil
+				}
+			}
+			p.pos = save
+		}
+		return string(utf8.RuneError), nil
+	}
+
+	if p.lenient {
+		return p.parseJSON5Escape()
+	}
+
+	return "", p.errorf("invalid character %s in string escape code", quoteChar(c))
+}
+
+// parseJSON5Escape handles the escapes JSON5 adds to JSON. Any character
+// without a special meaning stands for itself.
+func (p *parser) parseJSON5Escape() (string, error) {
+	c, _ := p.peek()
+	switch {
+	case c == 'v':
+		p.advance()
+		return "\v", nil
+	case c == '0' && !p.hasDigitAt(1):
+		p.advance()
+		return "\x00", nil
+	case c == 'x':
+		p.advance()
+		r, err := p.parseHex(2)
+		return string(r), err
+	case c == '\r':
+		p.advance()
+		if c, ok := p.peek(); ok && c == '\n' {
+			p.advance()
+		}
+		return "", nil
+	case c == '\n':
+		p.advance()
+		return "", nil
+	case isDigit(c):
+		return "", p.errorf("invalid character %s in string escape code", quoteChar(c))
+	}
+
+	r := p.peekRune()
+	p.advanceRune()
+	return string(r), nil
+}
+
+func (p *parser) parseHex(digits int) (rune, error) {
+	if p.pos.Offset+digits > len(p.data) {
+		return 0, p.errorf("unexpected end of JSON input")
+	}
+
+	var r rune
+	for i := 0; i < digits; i++ {
+		c := p.data[p.pos.Offset]
+		v, ok := hexValue(c)
+		if !ok {
+			return 0, p.errorf("invalid character %s in hexadecimal character escape", quoteChar(c))
+		}
+		r = r<<4 | rune(v)
+		p.advance()
+	}
+	return r, nil
+}
+
+func (p *parser) parseNumber() (string, error) {
+	start := p.pos.Offset
+	if err := p.skipNumber(); err != nil {
+		return "", err
+	}
+	return string(p.data[start:p.pos.Offset]), nil
+}
+
+// skipNumber moves past the number starting at the current position,
+// checking it like parseNumber.
+func (p *parser) skipNumber() error {
+	start := p.pos.Offset
+	if c, _ := p.peek(); c == '-' || p.lenient && c == '+' {
+		p.advance()
+	}
+
+	c, ok := p.peek()
+	switch {
+	case !ok:
+		return p.errorf("unexpected end of JSON input")
+	case p.lenient && c == 'I':
+		_, err := p.parseKeyword("Infinity")
+		return err
+	case p.lenient && c == 'N':
+		_, err := p.parseKeyword("NaN")
+		return err
+	case p.lenient && (p.hasPrefix("0x") || p.hasPrefix("0X")):
+		p.advance()
+		p.advance()
+		if c, ok := p.peek(); !ok || !isHexDigit(c) {
+			return p.errorf("invalid character %s in numeric literal", quoteChar(c))
+		}
+		for c, ok := p.peek(); ok && isHexDigit(c); c, ok = p.peek() {
+			p.advance()
+		}
+		return nil
+	case c == '0':
+		p.advance()
+	case isDigit(c):
+		p.skipDigits()
+	case p.lenient && c == '.':
+		// JSON5 allows a leading decimal point, which is handled below.
+	default:
+		return p.errorf("invalid character %s in numeric literal", quoteChar(c))
+	}
+
+	if c, ok := p.peek(); ok && c == '.' {
+		integer := p.pos.Offset > start && isDigit(p.data[p.pos.Offset-1])
+		p.advance()
+		if p.lenient && integer {
+			// JSON5 allows a trailing decimal point.
+			p.skipDigits()
+		} else if err := p.expectDigit(); err != nil {
+			return err
+		}
+	}
+
+	if c, ok := p.peek(); ok && (c == 'e' || c == 'E') {
+		p.advance()
+		if c, ok := p.peek(); ok && (c == '+' || c == '-') {
+			p.advance()
+		}
+		if err := p.expectDigit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *parser) expectDigit() error {
+	c, ok := p.peek()
+	if !ok {
+		return p.errorf("unexpected end of JSON input")
+	}
+	if !isDigit(c) {
+		return p.errorf("invalid character %s in numeric literal", quoteChar(c))
+	}
+	p.skipDigits()
+	return nil
+}
+
+func (p *parser) skipDigits() {
+	for c, ok := p.peek(); ok && isDigit(c); c, ok = p.peek() {
+		p.advance()
+	}
+}
+
+func (p *parser) parseKeyword(keyword string) (string, error) {
+	for i := 0; i < len(keyword); i++ {
+		c, ok := p.peek()
+		if !ok {
+			return "", p.errorf("unexpected end of JSON input")
+		}
+		if c != keyword[i] {
+			return "", p.errorf("invalid character %s in literal %s (expecting %s)", quoteChar(c), keyword, quoteChar(keyword[i]))
+		}
+		p.advance()
+	}
+	return keyword, nil
+}
+
+func (p *parser) expect(c byte, context string) error {
+	actual, ok := p.peek()
+	if !ok {
+		return p.errorf("unexpected end of JSON input")
+	}
+	if actual != c {
+		return p.errorf("invalid character %s %s", quoteChar(actual), context)
+	}
+	p.advance()
+	return nil
+}
+
+// skipWhitespace moves past whitespace and, in lenient mode, comments,
+// which are collected until they are attached to a node.
+func (p *parser) skipWhitespace() {
+	for c, ok := p.peek(); ok; c, ok = p.peek() {
+		switch {
+		case isWhitespace(c):
+			p.advance()
+		case p.lenient && (p.hasPrefix("//") || p.hasPrefix("/*")):
+			p.skipComment()
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) skipComment() {
+	start, line := p.pos.Offset, p.pos.Line
+	block := p.hasPrefix("/*")
+	p.advance()
+	p.advance()
+
+	for c, ok := p.peek(); ok; c, ok = p.peek() {
+		if block && p.hasPrefix("*/") {
+			p.advance()
+			p.advance()
+			break
+		}
+		if !block && c == '\n' {
+			break
+		}
+		p.advance()
+	}
+
+	text := strings.TrimRight(string(p.data[start:p.pos.Offset]), "\r")
+	p.comments = append(p.comments, comment{text, line})
+}
+
+func (p *parser) takeComments() []string {
+	var comments []string
+	for _, c := range p.comments {
+		comments = append(comments, c.text)
+	}
+	p.comments = nil
+	return comments
+}
+
+// takeLineComment returns the first pending comment if it starts on the
+// given line, i.e. on the same line as the value it follows.
+func (p *parser) takeLineComment(line int) string {
+	if len(p.comments) == 0 || p.comments[0].line != line {
+		return ""
+	}
+
+	text := p.comments[0].text
+	p.comments = p.comments[1:]
+	return text
+}
+
+func (p *parser) skipLine() {
+	for c, ok := p.peek(); ok; c, ok = p.peek() {
+		p.advance()
+		if c == '\n' {
+			return
+		}
+	}
+}
+
+// readComment reads a # comment up to the end of the line.
+func (p *parser) readComment() string {
+	line := p.restOfLine()
+	p.advanceBy(len(line))
+	return strings.TrimRight(line, " \t\r")
+}
+
+func (p *parser) restOfLine() string {
+	return restOfLine(p.data[p.pos.Offset:])
+}
+
+func (p *parser) peek() (byte, bool) {
+	if p.pos.Offset >= len(p.data) {
+		return 0, false
+	}
+	return p.data[p.pos.Offset], true
+}
+
+func (p *parser) peekRune() rune {
+	r, _ := utf8.DecodeRune(p.data[p.pos.Offset:])
+	return r
+}
+
+func (p *parser) hasPrefix(s string) bool {
+	return len(p.data)-p.pos.Offset >= len(s) && string(p.data[p.pos.Offset:p.pos.Offset+len(s)]) == s
+}
+
+func (p *parser) hasDigitAt(i int) bool {
+	return p.pos.Offset+i < len(p.data) && isDigit(p.data[p.pos.Offset+i])
+}
+
+// advance moves past the current byte, keeping track of line and column.
+func (p *parser) advance() {
+	c := p.data[p.pos.Offset]
+	p.pos.Offset++
+	switch {
+	case c == '\n':
+		p.pos.Line++
+		p.pos.Column = 1
+	case utf8.RuneStart(c):
+		p.pos.Column++
+	}
+}
+
+func (p *parser) advanceBy(n int) {
+	for i := 0; i < n; i++ {
+		p.advance()
+	}
+}
+
+func (p *parser) advanceRune() {
+	_, size := utf8.DecodeRune(p.data[p.pos.Offset:])
+	for i := 0; i < size; i++ {
+		p.advance()
+	}
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{Msg: fmt.Sprintf(format, args...), Pos: p.pos}
+}
+
+func restOfLine(data []byte) string {
+	if nl := bytes.IndexByte(data, '\n'); nl >= 0 {
+		return string(data[:nl])
+	}
+	return string(data)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	_, ok := hexValue(c)
+	return ok
+}
+
+func isWhitespace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isIdentifierStart(r rune) bool {
+	return r == '_' || r == '$' || unicode.IsLetter(r)
+}
+
+func isIdentifierPart(r rune) bool {
+	return isIdentifierStart(r) || unicode.IsDigit(r)
+}
+
+func hexValue(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+func quoteChar(c byte) string {
+	if c == '\'' {
+		return `'\''`
+	}
+	if c == '"' {
+		return `'"'`
+	}
+	s := strconv.Quote(string(c))
+	return "'" + s[1:len(s)-1] + "'"
+}
@@ -0,0 +1,57 @@
+package jsonast
+
+import "errors"
+
+// Progress is called regularly while JSON is parsed, with the number of
+// bytes parsed so far and the top-level value being built, which is nil
+// until it has started. It is called on the parsing goroutine, so it may
+// use Partial to take a copy of that value. Parsing stops with ErrCanceled
+// if it returns false.
+type Progress func(offset int, top *Node) bool
+
+// ErrCanceled is returned if parsing was canceled by a Progress function.
+var ErrCanceled = errors.New("parsing canceled")
+
+// progressInterval is the number of values parsed between calls to a
+// Progress function.
+const progressInterval = 4096
+
+// ParseProgress is like Parse, or ParseLenient if lenient is set, and
+// reports its progress to progress.
+func ParseProgress(data []byte, lenient bool, progress Progress) (*Node, error) {
+	p := newParser(data)
+	p.lenient, p.progress = lenient, progress
+	return p.parseDocument()
+}
+
+// Partial returns a deep copy of the top-level container top holding the
+// children that are complete, which are the ones added so far. The copy
+// shares no nodes with top, so it can be used on other goroutines while
+// parsing continues and while the result is changed afterwards, e.g. by
+// FindDuplicates. For indexed values the index built so far is copied.
+func Partial(top *Node) *Node {
+	if top.index != nil {
+		return top.index.partial()
+	}
+	return deepCopy(top, nil)
+}
+
+// deepCopy returns a copy of n and its descendants with the given parent.
+func deepCopy(n, parent *Node) *Node {
+	c := *n
+	c.Parent, c.Children = parent, nil
+	for _, child := range n.Children {
+		c.Children = append(c.Children, deepCopy(child, &c))
+	}
+	return &c
+}
+
+// reportProgress calls the Progress function for every progressInterval
+// values.
+func (p *parser) reportProgress() error {
+	p.values++
+	if p.values%progressInterval != 0 || p.progress(p.pos.Offset, p.top) {
+		return nil
+	}
+	return ErrCanceled
+}
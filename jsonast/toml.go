@@ -0,0 +1,518 @@
+package jsonast
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	tomlDateTime = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}([Tt ]\d{2}:\d{2}(:\d{2}(\.\d+)?)?([Zz]|[+-]\d{2}:\d{2})?)?$|^\d{2}:\d{2}(:\d{2}(\.\d+)?)?$`)
+	tomlDate     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	tomlInt      = regexp.MustCompile(`^[-+]?(0|[1-9](_?[0-9])*)$|^0x[0-9A-Fa-f](_?[0-9A-Fa-f])*$|^0o[0-7](_?[0-7])*$|^0b[01](_?[01])*$`)
+	tomlFloat    = regexp.MustCompile(`^[-+]?(0|[1-9](_?[0-9])*)(\.[0-9](_?[0-9])*)?([eE][-+]?[0-9](_?[0-9])*)?$|^[-+]?(inf|nan)$`)
+)
+
+type tomlParser struct {
+	*parser
+	root  *Node
+	table *Node
+
+	// members indexes the members of every table by key.
+	members map[*Node]map[string]*Node
+
+	// implicit marks tables that were only created as the parent of
+	// another table and may still be defined by their own header.
+	implicit map[*Node]bool
+
+	// sealed marks inline tables and arrays, which cannot be extended.
+	sealed map[*Node]bool
+
+	// tableArrays marks arrays created by [[array]] headers.
+	tableArrays map[*Node]bool
+}
+
+// ParseTOML parses a TOML document into an Object node. Tables become
+// objects and arrays of tables arrays of objects, both in the order they are
+// first defined. Date and time values are DateTime nodes. Comments are kept.
+func ParseTOML(data []byte) (*Node, error) {
+	p := &tomlParser{
+		parser:      newParser(data),
+		members:     make(map[*Node]map[string]*Node),
+		implicit:    make(map[*Node]bool),
+		sealed:      make(map[*Node]bool),
+		tableArrays: make(map[*Node]bool),
+	}
+	if p.hasPrefix("\xef\xbb\xbf") {
+		p.advanceRune()
+	}
+	p.root = &Node{Type: Object, Start: p.pos}
+	p.table = p.root
+
+	for {
+		p.skipBlankLines()
+		if _, ok := p.peek(); !ok {
+			break
+		}
+
+		comments := p.takeComments()
+		var n *Node
+		var err error
+		if p.hasPrefix("[") {
+			n, err = p.parseTableHeader()
+		} else {
+			n, err = p.parseKeyValue(p.table)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		p.skipSpaces()
+		if c, ok := p.peek(); ok && c == '#' {
+			if n.IsContainer() && !p.sealed[n] {
+				comments = append(comments, p.readComment())
+			} else {
+				n.LineComment = p.readComment()
+			}
+		}
+		n.Comments = append(comments, n.Comments...)
+
+		if c, ok := p.peek(); ok && c != '\n' && c != '\r' {
+			return nil, p.errorf("unexpected character %s after value", quoteChar(c))
+		}
+	}
+
+	p.root.End = p.pos
+	p.root.TrailingComments = p.takeComments()
+	return p.root, nil
+}
+
+// parseTableHeader reads a [table] or [[array.of.tables]] header and makes
+// the table it names the current one.
+func (p *tomlParser) parseTableHeader() (*Node, error) {
+	start := p.pos
+	array := p.hasPrefix("[[")
+	p.advance()
+	if array {
+		p.advance()
+	}
+
+	keys, err := p.parseKeyPath()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(']', "after table name"); err != nil {
+		return nil, err
+	}
+	if array {
+		if err := p.expect(']', "after array of tables name"); err != nil {
+			return nil, err
+		}
+	}
+
+	parent := p.root
+	for _, key := range keys[:len(keys)-1] {
+		if parent, err = p.descend(parent, key, true); err != nil {
+			return nil, err
+		}
+	}
+
+	key := keys[len(keys)-1]
+	existing := p.members[parent][key]
+	var table *Node
+	switch {
+	case array && existing == nil:
+		arr := &Node{Type: Array, Start: start}
+		p.addMember(parent, key, arr)
+		p.tableArrays[arr] = true
+		existing = arr
+		fallthrough
+	case array && p.tableArrays[existing]:
+		table = &Node{Type: Object, Parent: existing, Index: len(existing.Children), Start: start}
+		existing.Children = append(existing.Children, table)
+		p.table = table
+		return table, nil
+	case array:
+		return nil, p.errorf("cannot define %s as an array of tables, it already has a value", strings.Join(keys, "."))
+	case existing == nil:
+		table = &Node{Type: Object, Start: start}
+		p.addMember(parent, key, table)
+	case existing.Type == Object && p.implicit[existing]:
+		table = existing
+		delete(p.implicit, table)
+	default:
+		return nil, p.errorf("table %s is already defined", strings.Join(keys, "."))
+	}
+
+	p.table = table
+	return table, nil
+}
+
+// parseKeyValue reads a key = value pair into table and returns the value.
+func (p *tomlParser) parseKeyValue(table *Node) (*Node, error) {
+	keys, err := p.parseKeyPath()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect('=', "after key"); err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+
+	for _, key := range keys[:len(keys)-1] {
+		if table, err = p.descend(table, key, false); err != nil {
+			return nil, err
+		}
+	}
+
+	key := keys[len(keys)-1]
+	if p.members[table][key] != nil {
+		return nil, p.errorf("duplicate key %s", strings.Join(keys, "."))
+	}
+
+	n, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	p.addMember(table, key, n)
+	return n, nil
+}
+
+// descend returns the table key within parent, creating it if necessary.
+// Table headers descend into the last table of an array of tables.
+func (p *tomlParser) descend(parent *Node, key string, header bool) (*Node, error) {
+	n := p.members[parent][key]
+	switch {
+	case n == nil:
+		n = &Node{Type: Object, Start: p.pos}
+		p.addMember(parent, key, n)
+		if header {
+			p.implicit[n] = true
+		}
+		return n, nil
+	case header && p.tableArrays[n]:
+		return n.Children[len(n.Children)-1], nil
+	case n.Type == Object && !p.sealed[n]:
+		return n, nil
+	}
+	return nil, p.errorf("key %s already has a value", key)
+}
+
+func (p *tomlParser) addMember(table *Node, key string, n *Node) {
+	n.Parent, n.Index = table, len(table.Children)
+	n.Key, n.KeyLiteral = key, Quote(key)
+	table.Children = append(table.Children, n)
+	table.End = n.End
+
+	if p.members[table] == nil {
+		p.members[table] = make(map[string]*Node)
+	}
+	p.members[table][key] = n
+}
+
+// parseKeyPath reads a possibly dotted key such as a."b.c".d.
+func (p *tomlParser) parseKeyPath() ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpaces()
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+
+		p.skipSpaces()
+		if c, ok := p.peek(); !ok || c != '.' {
+			return keys, nil
+		}
+		p.advance()
+	}
+}
+
+func (p *tomlParser) parseKey() (string, error) {
+	c, ok := p.peek()
+	switch {
+	case !ok:
+		return "", p.errorf("unexpected end of input looking for key")
+	case c == '"' || c == '\'':
+		if p.hasPrefix(`"""`) || p.hasPrefix(`'''`) {
+			return "", p.errorf("multi-line strings cannot be used as keys")
+		}
+		return p.parseTOMLString()
+	}
+
+	start := p.pos.Offset
+	for c, ok := p.peek(); ok && isBareKeyChar(c); c, ok = p.peek() {
+		p.advance()
+	}
+	if p.pos.Offset == start {
+		return "", p.errorf("invalid character %s looking for key", quoteChar(c))
+	}
+	return string(p.data[start:p.pos.Offset]), nil
+}
+
+func (p *tomlParser) parseValue() (*Node, error) {
+	n := &Node{Start: p.pos}
+	c, ok := p.peek()
+	switch {
+	case !ok:
+		return nil, p.errorf("unexpected end of input looking for value")
+	case c == '"' || c == '\'':
+		value, err := p.parseTOMLString()
+		if err != nil {
+			return nil, err
+		}
+		setString(n, value)
+	case c == '[':
+		if err := p.parseArray(n); err != nil {
+			return nil, err
+		}
+	case c == '{':
+		if err := p.parseInlineTable(n); err != nil {
+			return nil, err
+		}
+	default:
+		if err := p.parseScalar(n); err != nil {
+			return nil, err
+		}
+	}
+
+	n.End = p.pos
+	return n, nil
+}
+
+func (p *tomlParser) parseArray(n *Node) error {
+	n.Type = Array
+	p.sealed[n] = true
+	p.advance()
+
+	for {
+		p.skipBlankLines()
+		comments := p.takeComments()
+		c, ok := p.peek()
+		if !ok {
+			return p.errorf("unexpected end of input in array")
+		}
+		if c == ']' {
+			p.advance()
+			n.EndComments = comments
+			return nil
+		}
+
+		child, err := p.parseValue()
+		if err != nil {
+			return err
+		}
+		child.Parent, child.Index = n, len(n.Children)
+		child.Comments = comments
+		n.Children = append(n.Children, child)
+
+		p.skipSpaces()
+		separated := false
+		if c, ok := p.peek(); ok && c == ',' {
+			p.advance()
+			separated = true
+		}
+		p.skipSpaces()
+		if c, ok := p.peek(); ok && c == '#' {
+			child.LineComment = p.readComment()
+		}
+		p.skipBlankLines()
+		if c, ok := p.peek(); !separated && (!ok || c != ']') {
+			return p.errorf("expected ',' or ']' in array")
+		}
+	}
+}
+
+func (p *tomlParser) parseInlineTable(n *Node) error {
+	n.Type = Object
+	p.advance()
+
+	for {
+		p.skipBlankLines()
+		p.comments = nil
+		c, ok := p.peek()
+		if !ok {
+			return p.errorf("unexpected end of input in inline table")
+		}
+		if c == '}' {
+			p.advance()
+			break
+		}
+
+		if _, err := p.parseKeyValue(n); err != nil {
+			return err
+		}
+
+		p.skipBlankLines()
+		if c, ok := p.peek(); ok && c == ',' {
+			p.advance()
+		} else if !ok || c != '}' {
+			return p.errorf("expected ',' or '}' in inline table")
+		}
+	}
+
+	p.comments = nil
+	p.sealed[n] = true
+	for _, child := range n.Children {
+		p.seal(child)
+	}
+	return nil
+}
+
+// seal marks the tables created by dotted keys within an inline table as
+// sealed as well.
+func (p *tomlParser) seal(n *Node) {
+	if n.Type == Object {
+		p.sealed[n] = true
+		for _, child := range n.Children {
+			p.seal(child)
+		}
+	}
+}
+
+// parseScalar reads a number, boolean or date/time value.
+func (p *tomlParser) parseScalar(n *Node) error {
+	start := p.pos.Offset
+	for c, ok := p.peek(); ok && isTOMLScalarChar(c); c, ok = p.peek() {
+		p.advance()
+		// A space may separate the date and time of a date-time.
+		if c, ok := p.peek(); ok && c == ' ' && tomlDate.Match(p.data[start:p.pos.Offset]) && p.hasDigitAt(1) {
+			p.advance()
+		}
+	}
+	literal := string(p.data[start:p.pos.Offset])
+
+	switch {
+	case literal == "true" || literal == "false":
+		n.Type, n.Literal = Bool, literal
+	case tomlDateTime.MatchString(literal):
+		n.Type, n.Literal = DateTime, literal
+	case tomlInt.MatchString(literal) || tomlFloat.MatchString(literal):
+		setNumber(n, literal)
+	default:
+		p.pos = n.Start
+		if literal == "" {
+			c, _ := p.peek()
+			return p.errorf("invalid character %s looking for value", quoteChar(c))
+		}
+		return p.errorf("invalid value %s", literal)
+	}
+	return nil
+}
+
+// parseTOMLString reads a basic or literal string, either of which may be
+// a multi-line string delimited by three quotes, and returns its value.
+func (p *tomlParser) parseTOMLString() (string, error) {
+	quote := p.data[p.pos.Offset]
+	delimiter := string(quote)
+	if p.hasPrefix(strings.Repeat(delimiter, 3)) {
+		delimiter = strings.Repeat(delimiter, 3)
+	}
+	multiline := len(delimiter) == 3
+	for range delimiter {
+		p.advance()
+	}
+
+	// A newline directly after the opening delimiter is not part of the
+	// string.
+	if multiline && p.hasPrefix("\n") {
+		p.advance()
+	} else if multiline && p.hasPrefix("\r\n") {
+		p.advanceBy(2)
+	}
+
+	var b strings.Builder
+	for {
+		c, ok := p.peek()
+		switch {
+		case !ok:
+			return "", p.errorf("unexpected end of input in string")
+		case p.hasPrefix(delimiter):
+			// Up to two quotes directly before the closing delimiter of a
+			// multi-line string belong to the string.
+			run := len(delimiter)
+			for multiline && run < 5 && p.pos.Offset+run < len(p.data) && p.data[p.pos.Offset+run] == quote {
+				run++
+			}
+			b.WriteString(strings.Repeat(string(quote), run-len(delimiter)))
+			p.advanceBy(run)
+			return b.String(), nil
+		case c == '\n' && !multiline:
+			return "", p.errorf("newline in single-line string")
+		case c == '\\' && quote == '"':
+			s, err := p.parseTOMLEscape(multiline)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(s)
+		case c == '\r' && p.hasPrefix("\r\n"):
+			p.advance()
+		default:
+			b.WriteRune(p.peekRune())
+			p.advanceRune()
+		}
+	}
+}
+
+func (p *tomlParser) parseTOMLEscape(multiline bool) (string, error) {
+	p.advance()
+	c, ok := p.peek()
+	if !ok {
+		return "", p.errorf("unexpected end of input in string")
+	}
+
+	if s, ok := tomlEscapes[c]; ok {
+		p.advance()
+		return s, nil
+	}
+
+	switch {
+	case c == 'x' || c == 'u' || c == 'U':
+		p.advance()
+		r, err := p.parseHex(map[byte]int{'x': 2, 'u': 4, 'U': 8}[c])
+		return string(r), err
+	case multiline && strings.TrimLeft(p.restOfLine(), " \t\r") == "":
+		// A backslash at the end of a line trims the following whitespace.
+		for c, ok := p.peek(); ok && isWhitespace(c); c, ok = p.peek() {
+			p.advance()
+		}
+		return "", nil
+	}
+
+	return "", p.errorf("invalid escape character %s in string", quoteChar(c))
+}
+
+var tomlEscapes = map[byte]string{
+	'b': "\b", 't': "\t", 'n': "\n", 'f': "\f", 'r': "\r", 'e': "\x1b",
+	'"': "\"", '\\': "\\",
+}
+
+// skipBlankLines moves past whitespace, line breaks and comments, which are
+// collected until they are attached to a node.
+func (p *tomlParser) skipBlankLines() {
+	for c, ok := p.peek(); ok; c, ok = p.peek() {
+		switch {
+		case isWhitespace(c):
+			p.advance()
+		case c == '#':
+			line := p.pos.Line
+			p.comments = append(p.comments, comment{p.readComment(), line})
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) skipSpaces() {
+	for c, ok := p.peek(); ok && (c == ' ' || c == '\t'); c, ok = p.peek() {
+		p.advance()
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || isDigit(c) || c == '_' || c == '-'
+}
+
+func isTOMLScalarChar(c byte) bool {
+	return isBareKeyChar(c) || c == '+' || c == '.' || c == ':'
+}
@@ -0,0 +1,928 @@
+package jsonast
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+var (
+	yamlNull  = regexp.MustCompile(`^(~|null|Null|NULL|)$`)
+	yamlBool  = regexp.MustCompile(`^(true|True|TRUE|false|False|FALSE)$`)
+	yamlInt   = regexp.MustCompile(`^([-+]?[0-9]+|0o[0-7]+|0x[0-9a-fA-F]+)$`)
+	yamlFloat = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$|^[-+]?\.(inf|Inf|INF)$|^\.(nan|NaN|NAN)$`)
+)
+
+type yamlParser struct {
+	*parser
+	anchors map[string]*Node
+}
+
+// ParseYAML parses a YAML stream. A single document is returned as its root
+// value, several documents separated by --- as a Stream node with one
+// record per document. Scalars are resolved using the YAML 1.2 core schema,
+// aliases are replaced by a copy of the anchored node and comments are kept.
+// Complex mapping keys are not supported.
+func ParseYAML(data []byte) (*Node, error) {
+	p := &yamlParser{parser: newParser(data), anchors: make(map[string]*Node)}
+	if p.hasPrefix("\xef\xbb\xbf") {
+		p.advanceRune()
+	}
+	return p.parseStream()
+}
+
+func (p *yamlParser) parseStream() (*Node, error) {
+	stream := &Node{Type: Stream, Start: p.pos}
+
+	for {
+		p.skipToContent()
+		for p.col() == 0 && p.hasPrefix("%") {
+			p.skipLine()
+			p.skipToContent()
+		}
+		if _, ok := p.peek(); !ok {
+			break
+		}
+
+		if p.atDocumentMarker("---") {
+			p.advanceBy(3)
+		}
+
+		doc, err := p.parseDocument(stream)
+		if err != nil {
+			return nil, err
+		}
+		stream.Children = append(stream.Children, doc)
+	}
+	stream.End = p.pos
+
+	switch len(stream.Children) {
+	case 0:
+		return &Node{Type: Null, Literal: "null", Comments: p.takeComments()}, nil
+	case 1:
+		root := stream.Children[0]
+		root.Parent = nil
+		root.TrailingComments = p.takeComments()
+		return root, nil
+	}
+
+	return stream, nil
+}
+
+func (p *yamlParser) parseDocument(stream *Node) (*Node, error) {
+	comments := p.takeComments()
+	n, err := p.parseNode(stream, len(stream.Children), -1, false)
+	if err != nil {
+		return nil, err
+	}
+	n.Comments = append(comments, n.Comments...)
+
+	p.skipToContent()
+	if p.atDocumentMarker("...") {
+		p.skipLine()
+	} else if _, ok := p.peek(); ok && !p.atDocumentMarker("---") {
+		return nil, p.errorf("unexpected content after end of document")
+	}
+
+	return n, nil
+}
+
+// parseNode parses the value of a mapping entry, sequence entry or document
+// starting at the current position. indent is the column of the entry; a
+// value on the following lines has to be indented further, except for a
+// sequence that is the value of a mapping entry.
+func (p *yamlParser) parseNode(parent *Node, index, indent int, mappingValue bool) (*Node, error) {
+	p.skipSpaces()
+	anchor, tag, err := p.parseProperties()
+	if err != nil {
+		return nil, err
+	}
+
+	var n *Node
+	var comments []string
+	if p.atLineEnd() {
+		if comment := p.readLineComment(); comment != "" {
+			comments = append(comments, comment)
+		}
+
+		p.skipToContent()
+		_, ok := p.peek()
+		switch {
+		case !ok || p.atDocumentMarker("---") || p.atDocumentMarker("..."):
+			n = p.newNull(parent, index)
+		case p.col() > indent:
+			n, err = p.parseContent(parent, index, indent, true)
+		case p.col() == indent && mappingValue && p.atSequenceEntry():
+			n, err = p.parseBlockSequence(parent, index, indent)
+		default:
+			n = p.newNull(parent, index)
+		}
+	} else {
+		n, err = p.parseContent(parent, index, indent, !mappingValue)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if tag != "" && n.Type != Object && n.Type != Array {
+		p.applyTag(n, tag)
+	}
+	if anchor != "" {
+		p.anchors[anchor] = n
+	}
+	n.Comments = append(comments, n.Comments...)
+
+	return n, nil
+}
+
+// parseContent parses a value that starts at the current position. compact
+// allows block collections starting on the same line, e.g. after "- ".
+func (p *yamlParser) parseContent(parent *Node, index, indent int, compact bool) (*Node, error) {
+	c, _ := p.peek()
+	switch {
+	case c == '?' && p.isIndicator(1):
+		return nil, p.errorf("complex mapping keys are not supported")
+	case compact && p.atSequenceEntry():
+		return p.parseBlockSequence(parent, index, p.col())
+	case compact && p.isMappingEntry():
+		return p.parseBlockMapping(parent, index, p.col())
+	case c == '|' || c == '>':
+		return p.parseBlockScalar(parent, index, indent)
+	}
+
+	n, err := p.parseFlowNode(parent, index, indent)
+	if err != nil {
+		return nil, err
+	}
+	n.LineComment = p.readLineComment()
+	if !p.atLineEnd() {
+		return nil, p.errorf("unexpected character %s after value", quoteChar(p.data[p.pos.Offset]))
+	}
+
+	return n, nil
+}
+
+func (p *yamlParser) parseBlockMapping(parent *Node, index, indent int) (*Node, error) {
+	n := &Node{Type: Object, Parent: parent, Index: index, Start: p.pos}
+
+	for {
+		comments := p.takeComments()
+		key, err := p.parseMappingKey()
+		if err != nil {
+			return nil, err
+		}
+
+		child, err := p.parseNode(n, len(n.Children), indent, true)
+		if err != nil {
+			return nil, err
+		}
+		child.Key, child.KeyLiteral = key, Quote(key)
+		child.Comments = append(comments, child.Comments...)
+		n.Children = append(n.Children, child)
+		n.End = child.End
+
+		if !p.nextEntry(indent) {
+			return n, nil
+		}
+		if !p.isMappingEntry() {
+			return nil, p.errorf("expected a mapping entry")
+		}
+	}
+}
+
+func (p *yamlParser) parseBlockSequence(parent *Node, index, indent int) (*Node, error) {
+	n := &Node{Type: Array, Parent: parent, Index: index, Start: p.pos}
+
+	for {
+		comments := p.takeComments()
+		p.advance()
+
+		child, err := p.parseNode(n, len(n.Children), indent, false)
+		if err != nil {
+			return nil, err
+		}
+		child.Comments = append(comments, child.Comments...)
+		n.Children = append(n.Children, child)
+		n.End = child.End
+
+		if !p.nextEntry(indent) || !p.atSequenceEntry() {
+			return n, nil
+		}
+	}
+}
+
+// nextEntry moves to the next content and reports whether it continues the
+// block collection at the given indentation.
+func (p *yamlParser) nextEntry(indent int) bool {
+	p.skipToContent()
+	if _, ok := p.peek(); !ok || p.col() < indent || p.atDocumentMarker("---") || p.atDocumentMarker("...") {
+		return false
+	}
+	return p.col() == indent && p.isFirstOnLine()
+}
+
+// parseMappingKey reads the key of a block mapping entry including the
+// following colon.
+func (p *yamlParser) parseMappingKey() (string, error) {
+	var key string
+	switch c, _ := p.peek(); c {
+	case '"', '\'':
+		var err error
+		if key, err = p.parseQuoted(); err != nil {
+			return "", err
+		}
+		p.skipSpaces()
+	default:
+		start := p.pos.Offset
+		for !p.atMappingIndicator() {
+			p.advanceRune()
+		}
+		key = strings.TrimRight(string(p.data[start:p.pos.Offset]), " \t")
+	}
+
+	if err := p.expect(':', "after mapping key"); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func (p *yamlParser) parseBlockScalar(parent *Node, index, indent int) (*Node, error) {
+	n := &Node{Type: String, Parent: parent, Index: index, Start: p.pos}
+	folded := p.data[p.pos.Offset] == '>'
+	p.advance()
+
+	var chomping byte
+	contentIndent := -1
+	for c, ok := p.peek(); ok; c, ok = p.peek() {
+		if c == '+' || c == '-' {
+			chomping = c
+		} else if '1' <= c && c <= '9' {
+			contentIndent = max(indent, 0) + int(c-'0')
+		} else {
+			break
+		}
+		p.advance()
+	}
+	n.LineComment = p.readLineComment()
+	if !p.atLineEnd() {
+		return nil, p.errorf("invalid block scalar header")
+	}
+
+	var lines []string
+	for c, ok := p.peek(); ok && c == '\n'; c, ok = p.peek() {
+		save := p.pos
+		p.advance()
+
+		spaces := 0
+		for p.pos.Offset+spaces < len(p.data) && p.data[p.pos.Offset+spaces] == ' ' {
+			spaces++
+		}
+		rest := strings.TrimRight(p.restOfLine()[spaces:], "\r")
+		if rest == "" && (contentIndent < 0 || spaces <= contentIndent) {
+			lines = append(lines, "")
+			p.advanceBy(len(p.restOfLine()))
+			continue
+		}
+
+		if contentIndent < 0 {
+			contentIndent = spaces
+		}
+		if spaces < contentIndent || spaces <= indent {
+			p.pos = save
+			break
+		}
+
+		line := p.restOfLine()
+		lines = append(lines, strings.TrimRight(line[contentIndent:], "\r"))
+		p.advanceBy(len(line))
+	}
+
+	n.Value = foldBlockScalar(lines, folded, chomping)
+	n.Literal = Quote(n.Value)
+	n.End = p.pos
+	return n, nil
+}
+
+// foldBlockScalar joins the content lines of a literal (|) or folded (>)
+// block scalar and applies the chomping indicator.
+func foldBlockScalar(lines []string, folded bool, chomping byte) string {
+	var b strings.Builder
+	emptyLines := 0
+	hasContent, moreIndented := false, false
+
+	for _, line := range lines {
+		if line == "" {
+			emptyLines++
+			continue
+		}
+
+		breaks := emptyLines
+		if hasContent {
+			breaks++
+		}
+
+		if folded {
+			switch {
+			case line[0] == ' ' || line[0] == '\t':
+				moreIndented = true
+			case moreIndented:
+				moreIndented = false
+				breaks = emptyLines + 1
+			case emptyLines == 0 && hasContent:
+				breaks = 0
+				b.WriteByte(' ')
+			case hasContent:
+				breaks = emptyLines
+			}
+		}
+
+		b.WriteString(strings.Repeat("\n", breaks))
+		b.WriteString(line)
+		hasContent, emptyLines = true, 0
+	}
+
+	switch {
+	case chomping == '+' && hasContent:
+		b.WriteString(strings.Repeat("\n", emptyLines+1))
+	case chomping == '+':
+		b.WriteString(strings.Repeat("\n", emptyLines))
+	case chomping != '-' && hasContent:
+		b.WriteByte('\n')
+	}
+
+	return b.String()
+}
+
+// parseFlowNode parses a value that is not a block collection: a flow
+// collection, an alias or a scalar. indent is used for the continuation
+// lines of plain scalars in block context and is -2 in flow context.
+func (p *yamlParser) parseFlowNode(parent *Node, index, indent int) (*Node, error) {
+	anchor, tag, err := p.parseProperties()
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Node{Parent: parent, Index: index, Start: p.pos}
+	switch c, _ := p.peek(); c {
+	case '[', '{':
+		err = p.parseFlowCollection(n)
+	case '*':
+		p.advance()
+		name := p.readName()
+		target, ok := p.anchors[name]
+		if !ok {
+			return nil, p.errorf("unknown anchor %s", name)
+		}
+		n = cloneNode(target, parent, index)
+	case '"', '\'':
+		var value string
+		if value, err = p.parseQuoted(); err == nil {
+			setString(n, value)
+		}
+	default:
+		var value string
+		if indent == flowIndent {
+			value = p.parsePlainFlow()
+		} else {
+			value, err = p.parsePlain(indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if value == "" && tag == "" {
+			return nil, p.errorf("unexpected character %s", quoteChar(c))
+		}
+		resolveScalar(n, value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	n.End = p.pos
+
+	if tag != "" && n.Type != Object && n.Type != Array {
+		p.applyTag(n, tag)
+	}
+	if anchor != "" {
+		p.anchors[anchor] = n
+	}
+
+	return n, nil
+}
+
+// flowIndent marks values inside flow collections, see parseFlowNode.
+const flowIndent = -2
+
+func (p *yamlParser) parseFlowCollection(n *Node) error {
+	closing := byte(']')
+	n.Type = Array
+	if p.data[p.pos.Offset] == '{' {
+		closing = '}'
+		n.Type = Object
+	}
+	p.advance()
+
+	for {
+		p.skipFlowSpace()
+		c, ok := p.peek()
+		if !ok {
+			return p.errorf("unexpected end of input in flow collection")
+		}
+		if c == closing {
+			p.advance()
+			return nil
+		}
+
+		var child *Node
+		var err error
+		if n.Type == Object {
+			child, err = p.parseFlowEntry(n)
+		} else {
+			child, err = p.parseFlowNode(n, len(n.Children), flowIndent)
+		}
+		if err != nil {
+			return err
+		}
+		n.Children = append(n.Children, child)
+
+		p.skipFlowSpace()
+		switch c, _ := p.peek(); c {
+		case ',':
+			p.advance()
+		case closing:
+		default:
+			return p.errorf("expected ',' or '%c' in flow collection", closing)
+		}
+	}
+}
+
+// parseFlowEntry parses a key: value pair of a flow mapping. The value may
+// be omitted, in which case it is null.
+func (p *yamlParser) parseFlowEntry(n *Node) (*Node, error) {
+	var key string
+	if c, _ := p.peek(); c == '"' || c == '\'' {
+		var err error
+		if key, err = p.parseQuoted(); err != nil {
+			return nil, err
+		}
+	} else {
+		key = p.parsePlainFlow()
+	}
+
+	p.skipFlowSpace()
+	var child *Node
+	if c, _ := p.peek(); c == ':' {
+		p.advance()
+		p.skipFlowSpace()
+		if c, _ := p.peek(); c == ',' || c == '}' {
+			child = p.newNull(n, len(n.Children))
+		} else {
+			var err error
+			if child, err = p.parseFlowNode(n, len(n.Children), flowIndent); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		child = p.newNull(n, len(n.Children))
+	}
+
+	child.Key, child.KeyLiteral = key, Quote(key)
+	return child, nil
+}
+
+// parsePlain reads a plain scalar in block context, including continuation
+// lines that are indented further than indent.
+func (p *yamlParser) parsePlain(indent int) (string, error) {
+	text, err := p.readPlainLine()
+	if err != nil {
+		return "", err
+	}
+
+	for p.atLineEnd() && !p.atComment() {
+		off, breaks := p.pos.Offset, 0
+		spaces := 0
+		for {
+			nl := bytes.IndexByte(p.data[off:], '\n')
+			if nl < 0 {
+				return text, nil
+			}
+			off += nl + 1
+			breaks++
+
+			spaces = 0
+			for off+spaces < len(p.data) && p.data[off+spaces] == ' ' {
+				spaces++
+			}
+			if rest := restOfLine(p.data[off+spaces:]); strings.TrimSpace(rest) != "" {
+				break
+			}
+		}
+
+		line := p.data[off+spaces:]
+		if spaces <= indent || line[0] == '#' || spaces == 0 && isDocumentMarker(line) {
+			return text, nil
+		}
+
+		if breaks == 1 {
+			text += " "
+		} else {
+			text += strings.Repeat("\n", breaks-1)
+		}
+		p.advanceBy(off + spaces - p.pos.Offset)
+		next, err := p.readPlainLine()
+		if err != nil {
+			return "", err
+		}
+		text += next
+	}
+
+	return text, nil
+}
+
+// readPlainLine reads a plain scalar up to the end of the line or a comment.
+// A colon followed by a space would start a mapping, which is not allowed
+// within a scalar, e.g. in a: b: c.
+func (p *yamlParser) readPlainLine() (string, error) {
+	line := p.restOfLine()
+	end := len(line)
+	for i := 1; i < len(line); i++ {
+		if line[i] == '#' && isYAMLSpace(line[i-1]) {
+			end = i
+			break
+		}
+	}
+
+	text := strings.TrimRight(line[:end], " \t\r")
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || isYAMLSpace(text[i+1])) {
+			p.advanceBy(i)
+			return "", p.errorf("mapping values are not allowed in this context")
+		}
+	}
+	p.advanceBy(len(text))
+	return text, nil
+}
+
+// parsePlainFlow reads a plain scalar inside a flow collection, which ends
+// at a flow indicator or a colon followed by a space.
+func (p *yamlParser) parsePlainFlow() string {
+	var b strings.Builder
+	for c, ok := p.peek(); ok; c, ok = p.peek() {
+		switch {
+		case strings.IndexByte(",[]{}", c) >= 0, p.atMappingIndicator(), p.atComment():
+			return strings.TrimSpace(b.String())
+		case c == '\n' || c == '\r':
+			p.advance()
+			b.WriteByte(' ')
+			p.skipSpaces()
+		default:
+			b.WriteRune(p.peekRune())
+			p.advanceRune()
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// parseQuoted reads a single or double quoted scalar, which may span several
+// lines.
+func (p *yamlParser) parseQuoted() (string, error) {
+	quote := p.data[p.pos.Offset]
+	p.advance()
+
+	var b []byte
+	for {
+		c, ok := p.peek()
+		switch {
+		case !ok:
+			return "", p.errorf("unexpected end of input in quoted scalar")
+		case c == quote && quote == '\'' && p.hasPrefix("''"):
+			p.advanceBy(2)
+			b = append(b, '\'')
+		case c == quote:
+			p.advance()
+			return string(b), nil
+		case c == '\\' && quote == '"':
+			s, err := p.parseYAMLEscape()
+			if err != nil {
+				return "", err
+			}
+			b = append(b, s...)
+		case c == '\n' || c == '\r':
+			b = append([]byte(strings.TrimRight(string(b), " \t")), p.foldLineBreaks()...)
+		default:
+			b = append(b, string(p.peekRune())...)
+			p.advanceRune()
+		}
+	}
+}
+
+// foldLineBreaks consumes line breaks within a quoted scalar. A single break
+// becomes a space, further empty lines become newlines.
+func (p *yamlParser) foldLineBreaks() string {
+	breaks := 0
+	for c, ok := p.peek(); ok; c, ok = p.peek() {
+		switch c {
+		case '\n':
+			breaks++
+			p.advance()
+		case ' ', '\t', '\r':
+			p.advance()
+		default:
+			if breaks == 1 {
+				return " "
+			}
+			return strings.Repeat("\n", breaks-1)
+		}
+	}
+	return ""
+}
+
+func (p *yamlParser) parseYAMLEscape() (string, error) {
+	p.advance()
+	c, ok := p.peek()
+	if !ok {
+		return "", p.errorf("unexpected end of input in quoted scalar")
+	}
+
+	if r, ok := yamlEscapes[c]; ok {
+		p.advance()
+		return r, nil
+	}
+
+	switch c {
+	case '\n', '\r':
+		// An escaped line break joins the lines without a space.
+		for c, ok := p.peek(); ok && (c == '\n' || c == '\r' || c == ' ' || c == '\t'); c, ok = p.peek() {
+			p.advance()
+		}
+		return "", nil
+	case 'x', 'u', 'U':
+		p.advance()
+		r, err := p.parseHex(map[byte]int{'x': 2, 'u': 4, 'U': 8}[c])
+		return string(r), err
+	}
+
+	return "", p.errorf("invalid escape character %s in quoted scalar", quoteChar(c))
+}
+
+var yamlEscapes = map[byte]string{
+	'0': "\x00", 'a': "\a", 'b': "\b", 't': "\t", '\t': "\t", 'n': "\n",
+	'v': "\v", 'f': "\f", 'r': "\r", 'e': "\x1b", ' ': " ", '"': "\"",
+	'/': "/", '\\': "\\", 'N': "\u0085", '_': "\u00a0", 'L': "\u2028",
+	'P': "\u2029",
+}
+
+// parseProperties reads an optional anchor and tag in front of a node.
+func (p *yamlParser) parseProperties() (anchor, tag string, err error) {
+	for {
+		switch c, _ := p.peek(); c {
+		case '&':
+			p.advance()
+			if anchor = p.readName(); anchor == "" {
+				return "", "", p.errorf("missing anchor name")
+			}
+		case '!':
+			tag = p.readName()
+		default:
+			return anchor, tag, nil
+		}
+		p.skipSpaces()
+	}
+}
+
+func (p *yamlParser) readName() string {
+	start := p.pos.Offset
+	for c, ok := p.peek(); ok && strings.IndexByte(" \t\r\n,[]{}", c) < 0; c, ok = p.peek() {
+		p.advance()
+	}
+	return string(p.data[start:p.pos.Offset])
+}
+
+// applyTag changes the type of a scalar according to a standard tag.
+func (p *yamlParser) applyTag(n *Node, tag string) {
+	value := n.Value
+	switch {
+	case n.Source != "":
+		value, n.Source = n.Source, ""
+	case n.Type != String:
+		value = n.Literal
+	}
+
+	switch tag {
+	case "!!str", "!!binary", "!!timestamp":
+		setString(n, value)
+	case "!!int", "!!float":
+		if yamlInt.MatchString(value) || yamlFloat.MatchString(value) {
+			setNumber(n, value)
+		}
+	case "!!bool":
+		if yamlBool.MatchString(value) {
+			n.Type, n.Literal, n.Value = Bool, strings.ToLower(value), ""
+		}
+	case "!!null":
+		n.Type, n.Literal, n.Value = Null, "null", ""
+	}
+}
+
+func (p *yamlParser) newNull(parent *Node, index int) *Node {
+	return &Node{Type: Null, Literal: "null", Parent: parent, Index: index, Start: p.pos, End: p.pos}
+}
+
+// resolveScalar determines the type of a plain scalar.
+func resolveScalar(n *Node, value string) {
+	switch {
+	case yamlNull.MatchString(value):
+		n.Type, n.Literal = Null, "null"
+	case yamlBool.MatchString(value):
+		n.Type, n.Literal = Bool, strings.ToLower(value)
+	case yamlInt.MatchString(value) || yamlFloat.MatchString(value):
+		setNumber(n, value)
+	default:
+		setString(n, value)
+	}
+}
+
+func setString(n *Node, value string) {
+	n.Type, n.Literal, n.Value = String, Quote(value), value
+}
+
+// cloneNode copies the tree of n for an alias, attaching it to parent.
+func cloneNode(n, parent *Node, index int) *Node {
+	c := *n
+	c.Parent, c.Index = parent, index
+	c.Key, c.KeyLiteral = "", ""
+	c.Comments, c.LineComment, c.EndComments = nil, "", nil
+
+	c.Children = nil
+	for i, child := range n.Children {
+		clone := cloneNode(child, &c, i)
+		clone.Key, clone.KeyLiteral = child.Key, child.KeyLiteral
+		c.Children = append(c.Children, clone)
+	}
+
+	return &c
+}
+
+// skipToContent moves to the next content, collecting comments on the way.
+func (p *yamlParser) skipToContent() {
+	for {
+		p.skipSpaces()
+		c, ok := p.peek()
+		switch {
+		case !ok:
+			return
+		case c == '#':
+			line := p.pos.Line
+			p.comments = append(p.comments, comment{p.readComment(), line})
+		case c == '\n':
+			p.advance()
+		default:
+			return
+		}
+	}
+}
+
+// skipFlowSpace moves past whitespace, line breaks and comments within a
+// flow collection.
+func (p *yamlParser) skipFlowSpace() {
+	for {
+		p.skipSpaces()
+		c, ok := p.peek()
+		switch {
+		case ok && c == '#':
+			p.readComment()
+		case ok && c == '\n':
+			p.advance()
+		default:
+			return
+		}
+	}
+}
+
+func (p *yamlParser) skipSpaces() {
+	for c, ok := p.peek(); ok && (c == ' ' || c == '\t' || c == '\r'); c, ok = p.peek() {
+		p.advance()
+	}
+}
+
+// readLineComment returns the comment following on the current line.
+func (p *yamlParser) readLineComment() string {
+	p.skipSpaces()
+	if c, ok := p.peek(); ok && c == '#' {
+		return p.readComment()
+	}
+	return ""
+}
+
+// atComment reports whether a comment starts at the current position, which
+// requires a preceding space.
+func (p *yamlParser) atComment() bool {
+	off := p.pos.Offset
+	return off < len(p.data) && p.data[off] == '#' && (off == 0 || isYAMLSpace(p.data[off-1]))
+}
+
+// atLineEnd reports whether only whitespace and comments follow on the
+// current line.
+func (p *yamlParser) atLineEnd() bool {
+	rest := strings.TrimLeft(p.restOfLine(), " \t\r")
+	return rest == "" || rest[0] == '#'
+}
+
+func (p *yamlParser) atSequenceEntry() bool {
+	c, ok := p.peek()
+	return ok && c == '-' && p.isIndicator(1)
+}
+
+// isIndicator reports whether the byte at offset i from the current
+// position is followed by whitespace or the end of input.
+func (p *yamlParser) isIndicator(i int) bool {
+	off := p.pos.Offset + i
+	return off == len(p.data) || off < len(p.data) && isYAMLSpace(p.data[off])
+}
+
+// atMappingIndicator reports whether the current position is a colon
+// followed by a space or at the end of the line, or at the end of the line
+// itself.
+func (p *yamlParser) atMappingIndicator() bool {
+	off := p.pos.Offset
+	if off >= len(p.data) || p.data[off] == '\n' {
+		return true
+	}
+	return p.data[off] == ':' && (off+1 == len(p.data) || isYAMLSpace(p.data[off+1]) || strings.IndexByte(",[]{}", p.data[off+1]) >= 0)
+}
+
+// isMappingEntry reports whether the current line continues with a
+// "key: value" mapping entry.
+func (p *yamlParser) isMappingEntry() bool {
+	line := strings.TrimRight(p.restOfLine(), "\r")
+	if line == "" || strings.IndexByte("[{-#", line[0]) >= 0 && !strings.HasPrefix(line, "-:") {
+		return false
+	}
+
+	i := 0
+	if quote := line[0]; quote == '"' || quote == '\'' {
+		for i = 1; i < len(line) && line[i] != quote; i++ {
+			if line[i] == '\\' && quote == '"' {
+				i++
+			}
+		}
+		i++
+		for i < len(line) && line[i] == ' ' {
+			i++
+		}
+		return i < len(line) && line[i] == ':' && (i+1 == len(line) || isYAMLSpace(line[i+1]))
+	}
+
+	for ; i < len(line); i++ {
+		switch {
+		case line[i] == ':' && (i+1 == len(line) || isYAMLSpace(line[i+1])):
+			return true
+		case line[i] == '#' && i > 0 && isYAMLSpace(line[i-1]):
+			return false
+		}
+	}
+	return false
+}
+
+func (p *yamlParser) atDocumentMarker(marker string) bool {
+	return p.col() == 0 && isDocumentMarker(p.data[p.pos.Offset:]) && p.hasPrefix(marker)
+}
+
+func isDocumentMarker(data []byte) bool {
+	s := string(data[:min(len(data), 4)])
+	if !strings.HasPrefix(s, "---") && !strings.HasPrefix(s, "...") {
+		return false
+	}
+	return len(s) == 3 || isYAMLSpace(s[3])
+}
+
+func (p *yamlParser) isFirstOnLine() bool {
+	for off := p.pos.Offset - 1; off >= 0 && p.data[off] != '\n'; off-- {
+		if p.data[off] != ' ' {
+			return false
+		}
+	}
+	return true
+}
+
+// col returns the zero based column of the current position.
+func (p *yamlParser) col() int {
+	return p.pos.Column - 1
+}
+
+func isYAMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -1,11 +1,17 @@
 package jsonfmt
 
 import (
-	"encoding/json"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"math"
+	"math/big"
 	"sort"
-	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/maxzender/jv/jsonast"
 )
 
 type TokenType int
@@ -18,6 +24,14 @@ const (
 	NullType
 	WhiteSpaceType
 	KeyType
+	LabelType
+	ErrorType
+	CommentType
+	DateTimeType
+	BytesType
+
+	// DuplicateKeyType is the key of a member marked as Duplicate.
+	DuplicateKeyType
 )
 
 type FormatWriter interface {
@@ -25,90 +39,519 @@ type FormatWriter interface {
 	Newline()
 }
 
+// StructureWriter can optionally be implemented by a FormatWriter to be
+// notified about the structure of the document while it is being written.
+// The node passed to each event provides its depth, index and key.
+//
+// BeginObject and BeginArray are called before the opening bracket is
+// written, EndContainer after the closing one. Key is called before the key
+// of an object member is written and Value before every value, including
+// the top-level one.
+type StructureWriter interface {
+	BeginObject(n *jsonast.Node)
+	BeginArray(n *jsonast.Node)
+	EndContainer(n *jsonast.Node)
+	Key(n *jsonast.Node)
+	Value(n *jsonast.Node)
+}
+
+// IndentationDepth is the default number of spaces per nesting level.
 const IndentationDepth = 4
 
+// TabWidth is the number of columns between tab stops, as in most
+// terminals.
+const TabWidth = 8
+
+// maxIntegerDigits is the length up to which normalized integers are
+// written without an exponent.
+const maxIntegerDigits = 21
+
+// maxExponent is the binary exponent, about 10^±4932, beyond which numbers
+// are not normalized.
+const maxExponent = 1 << 14
+
+// maxBytesPreview is the number of bytes shown of binary data. It is a
+// multiple of 3 so the base64 preview needs no padding.
+const maxBytesPreview = 24
+
+// Options control how a document is written. The zero value writes every
+// value on its own line, indented by IndentationDepth spaces per level.
+type Options struct {
+	// SortKeys renders object keys in lexical order instead of the
+	// order in which they appear in the input.
+	SortKeys bool
+
+	// NormalizeNumbers renders numbers in a canonical notation instead of
+	// the literal found in the input, e.g. 1.50E+2 becomes 150.
+	NormalizeNumbers bool
+
+	// UnescapeStrings renders strings and keys decoded, with control
+	// characters shown as visible symbols, instead of with the escape
+	// sequences used in the input.
+	UnescapeStrings bool
+
+	// BytesBase64 renders previews of binary data in base64 instead of
+	// hexadecimal.
+	BytesBase64 bool
+
+	// Indent is the number of spaces per nesting level, IndentationDepth
+	// if 0. Tabs indents with a tab per level instead.
+	Indent int
+	Tabs   bool
+
+	// InlineWidth is the number of columns up to which arrays and objects
+	// holding only scalars are written on a single line, e.g. [1, 2, 3].
+	// If it is 0, every value is written on its own line.
+	InlineWidth int
+
+	// AlignColons pads the keys of the members of an object, so their
+	// colons line up.
+	AlignColons bool
+
+	// Plain leaves out what is only meant for the reader, the labels of
+	// the records of a stream, comments and the errors of values that
+	// could not be parsed or were recovered, and writes values in JSON
+	// notation like jsonast.Compact, so other programs can read the output.
+	Plain bool
+}
+
 type Formatter struct {
-	rawJson []byte
-	depth   int
+	Options
+
+	root      *jsonast.Node
+	depth     int
+	structure StructureWriter
 	FormatWriter
+
+	// column is the column being written, only tracked for InlineWidth.
+	column int
+
+	// line is the number of the line being written. Format records the
+	// line on which each child of a container starts in starts, followed
+	// by the line after the last child, and the sorted members of objects
+	// in sorted, so FormatLines can skip the values outside the lines from
+	// up to to. keyWidths caches the width of the widest key of objects
+	// for AlignColons.
+	line      int
+	from, to  int
+	starts    map[*jsonast.Node][]int
+	sorted    map[*jsonast.Node][]*jsonast.Node
+	keyWidths map[interface{}]int
+
+	// layout takes the place of starts and sorted for indexed roots, see
+	// jsonast.Indexed; recorded is set if Layout records it.
+	layout   *jsonast.Layout
+	recorded bool
+
+	// records is the number of records of a Stream root written so far,
+	// last the line the last one ends on.
+	records, last int
 }
 
-func New(data []byte, w FormatWriter) *Formatter {
-	return &Formatter{data, 0, w}
+// New creates a formatter writing root to w as set by opts.
+func New(root *jsonast.Node, w FormatWriter, opts Options) *Formatter {
+	structure, _ := w.(StructureWriter)
+	f := &Formatter{Options: opts, root: root, structure: structure, FormatWriter: w}
+	f.keyWidths = map[interface{}]int{}
+	if root.Indexed() {
+		f.layout = jsonast.NewLayout(root, opts.SortKeys, false)
+	}
+	return f
+}
+
+// Format writes the whole document.
+func (f *Formatter) Format() {
+	f.line, f.from, f.to, f.column = 0, 0, math.MaxInt32, 0
+	f.starts = map[*jsonast.Node][]int{}
+	f.sorted = map[*jsonast.Node][]*jsonast.Node{}
+	f.keyWidths = map[interface{}]int{}
+	f.formatDocument()
+	f.records, f.last = f.root.Len(), f.line
 }
 
-func (f *Formatter) Format() error {
-	var v interface{}
-	if err := json.Unmarshal(f.rawJson, &v); err != nil {
-		return fmt.Errorf("parse error: %v", err)
+// Layout returns the lines of the values of an indexed root, which
+// FormatLines needs instead of Format. They follow from the index unless
+// keys are sorted or containers written on one line; then the whole
+// document is formatted once to record them.
+func (f *Formatter) Layout() *jsonast.Layout {
+	f.records, f.last = f.root.Len(), f.layout.Lines()-1
+	if f.SortKeys || f.InlineWidth > 0 {
+		f.layout, f.recorded = jsonast.NewLayout(f.root, f.SortKeys, true), true
+		saved, structure := f.FormatWriter, f.structure
+		f.FormatWriter, f.structure = discard{}, nil
+		f.Format()
+		f.FormatWriter, f.structure = saved, structure
 	}
+	return f.layout
+}
+
+// FormatLines writes only the lines from up to to of the document to w,
+// e.g. the ones visible on screen. Format, or Layout for indexed roots,
+// must have been called first; values outside the lines are skipped
+// without formatting them, so this is fast even for huge documents.
+func (f *Formatter) FormatLines(w FormatWriter, from, to int) {
+	saved, structure := f.FormatWriter, f.structure
+	defer func() {
+		f.FormatWriter, f.structure = saved, structure
+	}()
+
+	f.FormatWriter, f.structure = w, nil
+	f.line, f.from, f.to, f.depth, f.column = 0, from, to, 0, 0
+	f.formatDocument()
+}
 
-	f.format(v)
+// Write writes s if the current line is to be written.
+func (f *Formatter) Write(s string, t TokenType) {
+	if f.InlineWidth > 0 {
+		f.column = advance(f.column, s)
+	}
+	if f.line >= f.from && f.line < f.to {
+		f.FormatWriter.Write(s, t)
+	}
+}
 
-	return nil
+// Newline ends the current line.
+func (f *Formatter) Newline() {
+	f.line++
+	f.column = 0
+	if f.line > f.from && f.line < f.to {
+		f.FormatWriter.Newline()
+	}
 }
 
-func (f *Formatter) format(v interface{}) {
-	switch value := v.(type) {
-	case map[string]interface{}:
-		f.formatObject(value)
-	case []interface{}:
-		f.formatArray(value)
-	case bool:
-		f.Write(fmt.Sprintf("%t", value), BoolType)
-	case string:
-		f.Write(fmt.Sprintf(`"%s"`, value), StringType)
-	case float64:
-		f.Write(strconv.FormatFloat(value, 'f', -1, 64), NumberType)
-	case nil:
-		f.Write("null", NullType)
+// children returns the values of the container n in the order they are
+// written.
+func (f *Formatter) children(n *jsonast.Node) []*jsonast.Node {
+	if !f.SortKeys || n.Type != jsonast.Object {
+		return n.Children
 	}
+	if sorted, ok := f.sorted[n]; ok {
+		return sorted
+	}
+
+	sorted := append([]*jsonast.Node(nil), n.Children...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+	if f.sorted != nil {
+		f.sorted[n] = sorted
+	}
+	return sorted
 }
 
-func (f *Formatter) formatObject(obj map[string]interface{}) {
-	if len(obj) == 0 {
-		f.Write("{}", DelimiterType)
+// eachChild calls fn with the position and the value of the children of
+// the container n in the order they are written, from position i on, until
+// fn returns false.
+func (f *Formatter) eachChild(n *jsonast.Node, i int, fn func(i int, child *jsonast.Node) bool) {
+	if f.layout != nil && n.Indexed() {
+		f.layout.Range(n, i, func(child *jsonast.Node) bool {
+			i++
+			return fn(i-1, child)
+		})
 		return
 	}
 
-	f.Write("{", DelimiterType)
-	f.Newline()
-	f.depth++
+	children := f.children(n)
+	for ; i < len(children); i++ {
+		if !fn(i, children[i]) {
+			return
+		}
+	}
+}
 
-	var keys []string
-	for k := range obj {
-		keys = append(keys, k)
+// skip moves past the children of n that end before the first line to be
+// written and returns the index of the first one to write.
+func (f *Formatter) skip(n *jsonast.Node) int {
+	if f.line >= f.from {
+		return 0
+	}
+	if f.layout != nil && n.Indexed() {
+		i, line := f.layout.Skip(n, f.from)
+		f.line = line
+		return i
 	}
-	sort.Strings(keys)
 
-	end := len(keys)
-	for i, key := range keys {
-		val := obj[key]
-		jsonKey := fmt.Sprintf("\"%s\"", key)
+	starts := f.starts[n]
+	if len(starts) == 0 {
+		return 0
+	}
 
-		f.writeIndent()
-		f.Write(jsonKey, KeyType)
-		f.Write(":", DelimiterType)
+	i := sort.Search(len(starts)-1, func(i int) bool {
+		return starts[i+1] > f.from
+	})
+	f.line = starts[i]
+	return i
+}
+
+// lineStarts returns a slice to collect the starts of the children of n in
+// while the whole document is formatted, nil otherwise.
+func (f *Formatter) lineStarts(n *jsonast.Node) []int {
+	if f.starts == nil || f.to != math.MaxInt32 || n.Indexed() {
+		return nil
+	}
+	return make([]int, 0, len(n.Children)+1)
+}
+
+// storeStarts records the collected starts of the children of n and the
+// line after the last one.
+func (f *Formatter) storeStarts(n *jsonast.Node, starts []int, end int) {
+	if starts != nil {
+		f.starts[n] = append(starts, end)
+	}
+}
+
+// recordLines records the lines of the indexed container or stream n,
+// which starts on line start, while Layout formats the document.
+func (f *Formatter) recordLines(n *jsonast.Node, start int) {
+	if f.recorded && f.to == math.MaxInt32 && n.Indexed() {
+		f.layout.Record(n, start, f.line-start+1)
+	}
+}
+
+// recordChild records the line the child of n at position i starts on
+// while Layout formats the document.
+func (f *Formatter) recordChild(n *jsonast.Node, i int) {
+	if f.recorded && f.to == math.MaxInt32 && n.Indexed() {
+		f.layout.RecordChild(n, i, f.line)
+	}
+}
+
+func (f *Formatter) formatDocument() {
+	f.writeComments(f.root.Comments)
+	f.format(f.root)
+	f.writeLineComment(f.root)
+	if f.Plain {
+		return
+	}
+	for _, comment := range f.root.TrailingComments {
+		f.Newline()
+		f.writeComment(comment)
+	}
+}
+
+func (f *Formatter) format(n *jsonast.Node) {
+	if f.structure != nil {
+		f.structure.Value(n)
+	}
+
+	start := f.line
+	switch n.Type {
+	case jsonast.Object:
+		if f.structure != nil {
+			f.structure.BeginObject(n)
+		}
+		f.formatObject(n)
+		if f.structure != nil {
+			f.structure.EndContainer(n)
+		}
+		f.recordLines(n, start)
+	case jsonast.Array:
+		if f.structure != nil {
+			f.structure.BeginArray(n)
+		}
+		f.formatArray(n)
+		if f.structure != nil {
+			f.structure.EndContainer(n)
+		}
+		f.recordLines(n, start)
+	case jsonast.Bool:
+		f.Write(n.Literal, BoolType)
+	case jsonast.String:
+		f.writeString(n.Literal, n.Value, StringType)
+	case jsonast.Number:
+		f.writeNumber(n)
+	case jsonast.Null:
+		f.Write(n.Literal, NullType)
+	case jsonast.DateTime:
+		f.writeDateTime(n.Literal)
+	case jsonast.Bytes:
+		f.writeBytes(n.Value)
+	case jsonast.Stream:
+		f.formatStream(n)
+	case jsonast.Invalid:
+		f.formatInvalid(n)
+	}
+
+	if n.Err != nil && n.Type != jsonast.Invalid {
+		f.writeError(n.Err)
+	}
+}
+
+// formatStream writes every record of a stream on its own top-level line,
+// labelled with its record number.
+func (f *Formatter) formatStream(stream *jsonast.Node) {
+	starts := f.lineStarts(stream)
+	start := f.skip(stream)
+	f.eachChild(stream, start, func(i int, record *jsonast.Node) bool {
+		if f.line >= f.to {
+			return false
+		}
+		if i > start {
+			f.Newline()
+		}
+		if starts != nil {
+			starts = append(starts, f.line)
+		}
+		f.recordChild(stream, i)
+		f.formatRecord(record, i)
+		return true
+	})
+	f.storeStarts(stream, starts, f.line+1)
+	f.recordLines(stream, 0)
+	if start == stream.Len() && start > 0 {
+		// Unlike members, records are not followed by a newline.
+		f.line--
+	}
+}
+
+// FormatAppended writes the records appended to a Stream root since it was
+// formatted, continuing the lines written by Format.
+func (f *Formatter) FormatAppended() {
+	if f.root.Type != jsonast.Stream || f.records >= f.root.Len() {
+		return
+	}
+
+	done := f.records
+	f.line, f.from, f.to, f.depth, f.column = f.last, 0, math.MaxInt32, 0, 0
+
+	// The last start is the line after the last record.
+	starts := f.starts[f.root]
+	if len(starts) > 0 {
+		starts = starts[:done]
+	}
+	f.eachChild(f.root, done, func(i int, record *jsonast.Node) bool {
+		if i > 0 {
+			f.Newline()
+		}
+		if starts != nil {
+			starts = append(starts, f.line)
+		}
+		f.recordChild(f.root, i)
+		f.formatRecord(record, i)
+		return true
+	})
+	if starts != nil {
+		f.starts[f.root] = append(starts, f.line+1)
+	}
+	f.recordLines(f.root, 0)
+	f.records, f.last = f.root.Len(), f.line
+}
+
+// formatRecord writes the record with the given index of a stream,
+// labelled with its record number.
+func (f *Formatter) formatRecord(record *jsonast.Node, index int) {
+	f.writeComments(record.Comments)
+	if !f.Plain {
+		f.Write(fmt.Sprintf("#%d", index+1), LabelType)
 		f.Write(" ", WhiteSpaceType)
+	}
+	f.format(record)
+	f.writeLineComment(record)
+}
+
+func (f *Formatter) formatInvalid(n *jsonast.Node) {
+	for i, line := range strings.Split(n.Literal, "\n") {
+		if i > 0 {
+			f.Newline()
+		}
+		f.Write(strings.TrimRight(line, "\r"), ErrorType)
+	}
+
+	if n.Literal == "" && !f.Plain {
+		f.Write(fmt.Sprintf("(%v)", n.Err), ErrorType)
+	} else {
+		f.writeError(n.Err)
+	}
+}
+
+// writeError writes err after a value. It is used for Invalid nodes and
+// for the problems found by jsonast.ParseRecover.
+func (f *Formatter) writeError(err error) {
+	if f.Plain {
+		return
+	}
+	f.Write(" ", WhiteSpaceType)
+	f.Write(fmt.Sprintf("(%v)", err), ErrorType)
+}
+
+// writeClosing writes the closing bracket of a container, marked as error
+// if the container had to be closed automatically.
+func (f *Formatter) writeClosing(n *jsonast.Node, bracket string) {
+	if n.Err != nil {
+		f.Write(bracket, ErrorType)
+	} else {
+		f.Write(bracket, DelimiterType)
+	}
+}
+
+func (f *Formatter) formatObject(obj *jsonast.Node) {
+	if obj.Len() == 0 && (len(obj.EndComments) == 0 || f.Plain) {
+		if obj.Err != nil {
+			f.Write("{", DelimiterType)
+			f.writeClosing(obj, "}")
+		} else {
+			f.Write("{}", DelimiterType)
+		}
+		return
+	}
+
+	if f.inline(obj) {
+		f.formatInline(obj)
+		return
+	}
+
+	f.Write("{", DelimiterType)
+	f.Newline()
+	f.depth++
+
+	keyWidth := 0
+	if f.AlignColons {
+		keyWidth = f.keyWidth(obj)
+	}
 
-		f.format(val)
+	count := obj.Len()
+	starts := f.lineStarts(obj)
+	f.eachChild(obj, f.skip(obj), func(i int, member *jsonast.Node) bool {
+		if f.line >= f.to {
+			return false
+		}
+		if starts != nil {
+			starts = append(starts, f.line)
+		}
+		f.recordChild(obj, i)
+		f.writeComments(member.Comments)
+		f.writeKey(member, keyWidth)
+		f.format(member)
 
-		i++
-		if i < end {
+		if i+1 < count {
 			f.Write(",", DelimiterType)
 		}
 
+		f.writeLineComment(member)
 		f.Newline()
-	}
+		return true
+	})
+	f.storeStarts(obj, starts, f.line)
+	f.writeComments(obj.EndComments)
 
 	f.depth--
 	f.writeIndent()
-	f.Write("}", DelimiterType)
+	f.writeClosing(obj, "}")
 }
 
-func (f *Formatter) formatArray(a []interface{}) {
-	if len(a) == 0 {
-		f.Write("[]", DelimiterType)
+func (f *Formatter) formatArray(a *jsonast.Node) {
+	if a.Len() == 0 && (len(a.EndComments) == 0 || f.Plain) {
+		if a.Err != nil {
+			f.Write("[", DelimiterType)
+			f.writeClosing(a, "]")
+		} else {
+			f.Write("[]", DelimiterType)
+		}
+		return
+	}
+
+	if f.inline(a) {
+		f.formatInline(a)
 		return
 	}
 
@@ -116,25 +559,346 @@ func (f *Formatter) formatArray(a []interface{}) {
 	f.Newline()
 	f.depth++
 
-	i, end := 0, len(a)
-	for _, v := range a {
+	count := a.Len()
+	starts := f.lineStarts(a)
+	f.eachChild(a, f.skip(a), func(i int, v *jsonast.Node) bool {
+		if f.line >= f.to {
+			return false
+		}
+		if starts != nil {
+			starts = append(starts, f.line)
+		}
+		f.recordChild(a, i)
+		f.writeComments(v.Comments)
 		f.writeIndent()
 		f.format(v)
 
-		i++
-		if i < end {
+		if i+1 < count {
 			f.Write(",", DelimiterType)
 		}
 
+		f.writeLineComment(v)
 		f.Newline()
-	}
+		return true
+	})
+	f.storeStarts(a, starts, f.line)
+	f.writeComments(a.EndComments)
 
 	f.depth--
 	f.writeIndent()
-	f.Write("]", DelimiterType)
+	f.writeClosing(a, "]")
+}
+
+// inline reports whether the container n is written on a single line: it
+// has to hold only scalars, without comments or problems, and fit into
+// InlineWidth including a comma after it.
+func (f *Formatter) inline(n *jsonast.Node) bool {
+	if f.InlineWidth <= 0 || n.Err != nil || len(n.EndComments) > 0 {
+		return false
+	}
+
+	// Every value takes at least a column and the separator after it two,
+	// which rules out long containers without looking at their values.
+	if f.column+3*n.Len() > f.InlineWidth {
+		return false
+	}
+	scalars := true
+	n.Range(0, func(child *jsonast.Node) bool {
+		scalars = !child.IsContainer() && child.Type != jsonast.Invalid && child.Err == nil &&
+			len(child.Comments) == 0 && child.LineComment == "" && (n.Type != jsonast.Object || child.KeyLiteral != "")
+		return scalars
+	})
+
+	return scalars && f.column+f.inlineWidth(n)+1 <= f.InlineWidth
+}
+
+// inlineWidth returns the number of columns the container n takes when
+// written on a single line.
+func (f *Formatter) inlineWidth(n *jsonast.Node) int {
+	saved, column, from, to := f.FormatWriter, f.column, f.from, f.to
+	defer func() {
+		f.FormatWriter, f.column, f.from, f.to = saved, column, from, to
+	}()
+
+	f.FormatWriter, f.column, f.from, f.to = discard{}, 0, f.line, f.line+1
+	f.formatInline(n)
+	return f.column
+}
+
+// formatInline writes the container n on a single line. Its values are not
+// reported to the StructureWriter, as the line belongs to n.
+func (f *Formatter) formatInline(n *jsonast.Node) {
+	structure := f.structure
+	f.structure = nil
+	defer func() {
+		f.structure = structure
+	}()
+
+	opening, closing := "[", "]"
+	if n.Type == jsonast.Object {
+		opening, closing = "{", "}"
+	}
+
+	f.Write(opening, DelimiterType)
+	f.eachChild(n, 0, func(i int, child *jsonast.Node) bool {
+		if i > 0 {
+			f.Write(",", DelimiterType)
+			f.Write(" ", WhiteSpaceType)
+		}
+		if n.Type == jsonast.Object {
+			f.writeKeyName(child, 0)
+		}
+		f.format(child)
+		return true
+	})
+	f.Write(closing, DelimiterType)
+}
+
+// keyWidth returns the number of columns of the widest key of obj.
+func (f *Formatter) keyWidth(obj *jsonast.Node) int {
+	if width, ok := f.keyWidths[cacheKey(obj)]; ok {
+		return width
+	}
+
+	width := 0
+	obj.Range(0, func(member *jsonast.Node) bool {
+		width = max(width, utf8.RuneCountInString(f.stringText(member.KeyLiteral, member.Key)))
+		return true
+	})
+	f.keyWidths[cacheKey(obj)] = width
+	return width
+}
+
+// cacheKey identifies n in the caches of the formatter. Indexed nodes are
+// decoded anew whenever they are written, so they are identified by their
+// offset.
+func cacheKey(n *jsonast.Node) interface{} {
+	if n.Indexed() {
+		return n.Start.Offset
+	}
+	return n
+}
+
+// writeComments writes each comment on its own lines at the current depth.
+func (f *Formatter) writeComments(comments []string) {
+	if f.Plain {
+		return
+	}
+	for _, comment := range comments {
+		f.writeIndent()
+		f.writeComment(comment)
+		f.Newline()
+	}
+}
+
+func (f *Formatter) writeLineComment(n *jsonast.Node) {
+	if n.LineComment != "" && !f.Plain {
+		f.Write(" ", WhiteSpaceType)
+		f.writeComment(n.LineComment)
+	}
+}
+
+// writeComment writes a comment, continuing the lines of a block comment
+// aligned with its first line.
+func (f *Formatter) writeComment(comment string) {
+	for i, line := range strings.Split(comment, "\n") {
+		if i > 0 {
+			f.Newline()
+			f.writeIndent()
+			line = " " + strings.TrimLeft(line, " \t")
+		}
+		line = strings.Replace(strings.TrimRight(line, "\r"), "\t", " ", -1)
+		f.Write(line, CommentType)
+	}
+}
+
+// writeNumber writes a number as written in the source, e.g. 0x1F in YAML,
+// or normalized. Plain writes it in JSON notation like jsonast.Compact,
+// with the infinities and NaN quoted.
+func (f *Formatter) writeNumber(n *jsonast.Node) {
+	literal := n.Literal
+	switch {
+	case f.Plain:
+		literal = jsonast.Compact(n)
+	case n.Source != "" && !f.NormalizeNumbers:
+		literal = n.Source
+	}
+	if f.NormalizeNumbers {
+		literal = normalizeNumber(literal)
+	}
+	f.Write(literal, NumberType)
+}
+
+// writeDateTime writes a date or time as written in the source, or quoted
+// for Plain.
+func (f *Formatter) writeDateTime(literal string) {
+	if f.Plain {
+		literal = jsonast.Quote(literal)
+	}
+	f.Write(literal, DateTimeType)
+}
+
+// writeBytes writes a preview of binary data in the notation of CBOR
+// diagnostics, e.g. h'cafe' or b64'yv4=', followed by the length if the
+// data is cut off. Plain writes all of it as a base64 string.
+func (f *Formatter) writeBytes(b string) {
+	if f.Plain {
+		f.Write(jsonast.Quote(base64.StdEncoding.EncodeToString([]byte(b))), BytesType)
+		return
+	}
+	preview := []byte(b[:min(len(b), maxBytesPreview)])
+
+	var text string
+	if f.BytesBase64 {
+		text = "b64'" + base64.StdEncoding.EncodeToString(preview)
+	} else {
+		text = "h'" + hex.EncodeToString(preview)
+	}
+	if len(b) > maxBytesPreview {
+		text += fmt.Sprintf("…' (%d bytes)", len(b))
+	} else {
+		text += "'"
+	}
+
+	f.Write(text, BytesType)
+}
+
+func (f *Formatter) writeString(literal, value string, t TokenType) {
+	f.Write(f.stringText(literal, value), t)
+}
+
+// stringText returns the text shown for a string or key, quoted anew for
+// Plain, e.g. for the single quotes of JSON5.
+func (f *Formatter) stringText(literal, value string) string {
+	if f.Plain {
+		return jsonast.Quote(value)
+	}
+	if f.UnescapeStrings {
+		return `"` + strings.Map(visualizeControl, value) + `"`
+	}
+	return literal
+}
+
+// writeKey starts the line of an object member with its key, padded to
+// width columns.
+func (f *Formatter) writeKey(member *jsonast.Node, width int) {
+	if f.structure != nil {
+		f.structure.Key(member)
+	}
+	f.writeIndent()
+	f.writeKeyName(member, width)
+}
+
+func (f *Formatter) writeKeyName(member *jsonast.Node, width int) {
+	if member.KeyLiteral == "" {
+		// Recovered members may lack a key.
+		return
+	}
+	keyType := TokenType(KeyType)
+	if member.Duplicate {
+		keyType = DuplicateKeyType
+	}
+	key := f.stringText(member.KeyLiteral, member.Key)
+	f.Write(key, keyType)
+	if padding := width - utf8.RuneCountInString(key); padding > 0 {
+		f.Write(strings.Repeat(" ", padding), WhiteSpaceType)
+	}
+	f.Write(":", DelimiterType)
+	f.Write(" ", WhiteSpaceType)
 }
 
 func (f *Formatter) writeIndent() {
-	indentation := strings.Repeat(` `, f.depth*IndentationDepth)
-	f.Write(indentation, WhiteSpaceType)
+	if f.Tabs {
+		f.Write(strings.Repeat("\t", f.depth), WhiteSpaceType)
+		return
+	}
+
+	indent := f.Indent
+	if indent == 0 {
+		indent = IndentationDepth
+	}
+	f.Write(strings.Repeat(" ", f.depth*indent), WhiteSpaceType)
+}
+
+// advance returns the column after writing s at column.
+func advance(column int, s string) int {
+	for _, r := range s {
+		if r == '\t' {
+			column += TabWidth - column%TabWidth
+		} else {
+			column++
+		}
+	}
+	return column
+}
+
+// discard is a FormatWriter writing nothing, to measure text.
+type discard struct{}
+
+// Discard is a FormatWriter writing nothing, e.g. for a formatter only used
+// with FormatLines.
+var Discard FormatWriter = discard{}
+
+func (discard) Write(string, TokenType) {}
+func (discard) Newline()                {}
+
+// normalizeNumber converts a JSON number literal into a canonical notation
+// without going through float64, so no precision is lost. Integers are
+// written out in full unless they would get unreasonably long, infinities
+// and NaN are left as they are. So are numbers with exponents beyond
+// maxExponent, which take long to convert or are rounded to zero.
+func normalizeNumber(literal string) string {
+	prec := uint(len(literal))*4 + 64
+	f, _, err := big.ParseFloat(literal, 0, prec, big.ToNearestEven)
+	if err != nil || f.IsInf() {
+		return literal
+	}
+	if f.Sign() == 0 {
+		if mantissa := strings.SplitN(strings.ToLower(literal), "e", 2)[0]; strings.ContainsAny(mantissa, "123456789") {
+			return literal
+		}
+		return "0"
+	}
+
+	exp := f.MantExp(nil)
+	if exp > maxExponent || exp < -maxExponent {
+		return literal
+	}
+	// 10^maxIntegerDigits is less than 2^(4*maxIntegerDigits), so larger
+	// integers are not converted just to find they are too long.
+	if f.IsInt() && exp <= 4*maxIntegerDigits {
+		if i, _ := f.Int(nil); len(i.String()) <= maxIntegerDigits {
+			return i.String()
+		}
+	}
+
+	return f.Text('g', -1)
+}
+
+// visualizeControl maps control characters to printable symbols so decoded
+// strings never break the line model, e.g. a newline becomes '␊'.
+func visualizeControl(r rune) rune {
+	switch {
+	case r < 0x20:
+		return 0x2400 + r
+	case r == 0x7f:
+		return '␡'
+	case unicode.IsControl(r):
+		return unicode.ReplacementChar
+	}
+	return r
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
 }
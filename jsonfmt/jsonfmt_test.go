@@ -3,9 +3,13 @@ package jsonfmt
 import (
 	"bytes"
 	"fmt"
+	"math"
+	"reflect"
 	"strings"
 	"testing"
 	"unicode"
+
+	"github.com/maxzender/jv/jsonast"
 )
 
 type example struct {
@@ -28,6 +32,14 @@ func (w *stringWriter) Newline() {
 	w.Buffer.WriteString("\n")
 }
 
+func parse(t *testing.T, input string) *jsonast.Node {
+	root, err := jsonast.Parse([]byte(input))
+	if err != nil {
+		t.Fatalf("Parse(%v): %v", input, err)
+	}
+	return root
+}
+
 // Test correct colorization of output
 var colorExamples = []example{
 	{`{}`, `RED{}`},
@@ -36,6 +48,9 @@ var colorExamples = []example{
 	{`{"test":4}`, `RED{WHITE"test"RED:YELLOW4RED}`},
 	{`{"test":3.14159265359}`, `RED{WHITE"test"RED:YELLOW3.14159265359RED}`},
 	{`{"test":null}`, `RED{WHITE"test"RED:BLACKnullRED}`},
+	{`{"test":9007199254740993}`, `RED{WHITE"test"RED:YELLOW9007199254740993RED}`},
+	{`{"test":1E300}`, `RED{WHITE"test"RED:YELLOW1E300RED}`},
+	{`{"test":-0.10}`, `RED{WHITE"test"RED:YELLOW-0.10RED}`},
 }
 
 func TestFormatColors(t *testing.T) {
@@ -59,11 +74,9 @@ func TestFormatColors(t *testing.T) {
 
 	for _, tt := range colorExamples {
 		writer := &stringWriter{colorMap: colorMap}
-		formatter := New([]byte(tt.input), writer)
+		formatter := New(parse(t, tt.input), writer, Options{})
 
-		if err := formatter.Format(); err != nil {
-			t.Errorf("Format(%v): %v", tt.expected, err)
-		}
+		formatter.Format()
 
 		actual := removeWhiteSpace(writer.String())
 		if actual != tt.expected {
@@ -76,19 +89,37 @@ func TestFormatColors(t *testing.T) {
 var indentationExamples = []example{
 	{`{}`, `{}`},
 	{`{"test":true}`, "{\n    \"test\": true\n}"},
-	{`{"foo":true,"bar":"baz"}`, "{\n    \"bar\": \"baz\",\n    \"foo\": true\n}"},
-	{`{"foo":{},"bar":["test", "baz"]}`, "{\n    \"bar\": [\n        \"test\",\n        \"baz\"\n    ],\n    \"foo\": {}\n}"},
-	{`{"foo":{},"bar":{"test": "baz"}}`, "{\n    \"bar\": {\n        \"test\": \"baz\"\n    },\n    \"foo\": {}\n}"},
+	{`{"foo":true,"bar":"baz"}`, "{\n    \"foo\": true,\n    \"bar\": \"baz\"\n}"},
+	{`{"foo":{},"bar":["test", "baz"]}`, "{\n    \"foo\": {},\n    \"bar\": [\n        \"test\",\n        \"baz\"\n    ]\n}"},
+	{`{"foo":{},"bar":{"test": "baz"}}`, "{\n    \"foo\": {},\n    \"bar\": {\n        \"test\": \"baz\"\n    }\n}"},
 }
 
 func TestFormatIndentation(t *testing.T) {
 	for _, tt := range indentationExamples {
 		writer := &stringWriter{}
-		formatter := New([]byte(tt.input), writer)
+		formatter := New(parse(t, tt.input), writer, Options{})
 
-		if err := formatter.Format(); err != nil {
-			t.Errorf("Format(%v): %v", tt.expected, err)
+		formatter.Format()
+
+		actual := writer.String()
+		if actual != tt.expected {
+			t.Errorf("Format(%v):\n%v\nwant:\n%v", tt.input, actual, tt.expected)
 		}
+	}
+}
+
+// Test key order when sorting is requested
+var sortedExamples = []example{
+	{`{"foo":true,"bar":"baz"}`, "{\n    \"bar\": \"baz\",\n    \"foo\": true\n}"},
+	{`{"foo":{"b":1,"a":2},"bar":[{"y":1,"x":2}]}`, "{\n    \"bar\": [\n        {\n            \"x\": 2,\n            \"y\": 1\n        }\n    ],\n    \"foo\": {\n        \"a\": 2,\n        \"b\": 1\n    }\n}"},
+}
+
+func TestFormatSortKeys(t *testing.T) {
+	for _, tt := range sortedExamples {
+		writer := &stringWriter{}
+		formatter := New(parse(t, tt.input), writer, Options{SortKeys: true})
+
+		formatter.Format()
 
 		actual := writer.String()
 		if actual != tt.expected {
@@ -96,3 +127,481 @@ func TestFormatIndentation(t *testing.T) {
 		}
 	}
 }
+
+// Test normalized rendering of numbers
+var normalizedNumberExamples = []example{
+	{`1`, `1`},
+	{`1.50E+2`, `150`},
+	{`-0.10`, `-0.1`},
+	{`9007199254740993`, `9007199254740993`},
+	{`12345678901234567890123.0`, `1.2345678901234567890123e+22`},
+	{`1e300`, `1e+300`},
+	{`0.000001`, `1e-06`},
+	{`0x1F`, `31`},
+	{`+.5`, `0.5`},
+	{`5.`, `5`},
+	{`-Infinity`, `-Infinity`},
+	{`NaN`, `NaN`},
+	{`-inf`, `-inf`},
+	{`0.0e5`, `0`},
+	{`1e-999999999`, `1e-999999999`},
+	{`1e10000000`, `1e10000000`},
+	{`-2.5e-4000`, `-2.5e-4000`},
+}
+
+func TestFormatNormalizeNumbers(t *testing.T) {
+	for _, tt := range normalizedNumberExamples {
+		root, err := jsonast.ParseLenient([]byte(tt.input))
+		if err != nil {
+			root = &jsonast.Node{Type: jsonast.Number, Literal: tt.input}
+		}
+
+		writer := &stringWriter{}
+		formatter := New(root, writer, Options{NormalizeNumbers: true})
+
+		formatter.Format()
+
+		actual := writer.String()
+		if actual != tt.expected {
+			t.Errorf("Format(%v): %v, want %v", tt.input, actual, tt.expected)
+		}
+	}
+}
+
+func TestFormatNumberSource(t *testing.T) {
+	root, err := jsonast.ParseYAML([]byte("a: 0x1F\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, normalize := range []bool{false, true} {
+		writer := &stringWriter{}
+		New(root, writer, Options{NormalizeNumbers: normalize}).Format()
+
+		expected := "{\n    \"a\": 0x1F\n}"
+		if normalize {
+			expected = "{\n    \"a\": 31\n}"
+		}
+		if actual := writer.String(); actual != expected {
+			t.Errorf("Format(normalize %v): %q, want %q", normalize, actual, expected)
+		}
+	}
+}
+
+// Test rendering of escaped and unescaped strings
+var escapedExamples = []example{
+	{`"foo"`, `"foo"`},
+	{`"line\nbreak"`, `"line\nbreak"`},
+	{`{"say \"hi\"":"caf\u00e9\t\\"}`, "{\n    \"say \\\"hi\\\"\": \"caf\\u00e9\\t\\\\\"\n}"},
+}
+
+var unescapedExamples = []example{
+	{`"foo"`, `"foo"`},
+	{`"line\nbreak"`, `"line␊break"`},
+	{`{"say \"hi\"":"caf\u00e9\t\\"}`, "{\n    \"say \"hi\"\": \"café␉\\\"\n}"},
+	{`"\u0000\u007f\u0085"`, "\"␀␡\ufffd\""},
+}
+
+func TestFormatStrings(t *testing.T) {
+	for _, unescape := range []bool{false, true} {
+		examples := escapedExamples
+		if unescape {
+			examples = unescapedExamples
+		}
+
+		for _, tt := range examples {
+			writer := &stringWriter{}
+			formatter := New(parse(t, tt.input), writer, Options{UnescapeStrings: unescape})
+
+			formatter.Format()
+
+			actual := writer.String()
+			if actual != tt.expected {
+				t.Errorf("Format(%v): %v, want %v", tt.input, actual, tt.expected)
+			}
+		}
+	}
+}
+
+// Records the structure events received from the formatter
+type structureWriter struct {
+	stringWriter
+	events []string
+}
+
+func (w *structureWriter) BeginObject(n *jsonast.Node) {
+	w.events = append(w.events, fmt.Sprintf("object %d %d", n.Depth(), n.Index))
+}
+
+func (w *structureWriter) BeginArray(n *jsonast.Node) {
+	w.events = append(w.events, fmt.Sprintf("array %d %d", n.Depth(), n.Index))
+}
+
+func (w *structureWriter) EndContainer(n *jsonast.Node) {
+	w.events = append(w.events, fmt.Sprintf("end %d", n.Depth()))
+}
+
+func (w *structureWriter) Key(n *jsonast.Node) {
+	w.events = append(w.events, fmt.Sprintf("key %d %d %s", n.Depth(), n.Index, n.Key))
+}
+
+func (w *structureWriter) Value(n *jsonast.Node) {
+	w.events = append(w.events, fmt.Sprintf("value %s", n.Path()))
+}
+
+func TestFormatStructure(t *testing.T) {
+	input := `{"foo":[1,{}],"b\"ar":"x"}`
+	expected := []string{
+		"value .",
+		"object 0 0",
+		"key 1 0 foo",
+		"value .foo",
+		"array 1 0",
+		"value .foo[0]",
+		"value .foo[1]",
+		"object 2 1",
+		"end 2",
+		"end 1",
+		"key 1 1 b\"ar",
+		"value .[\"b\\\"ar\"]",
+		"end 0",
+	}
+
+	writer := &structureWriter{}
+	New(parse(t, input), writer, Options{}).Format()
+
+	if !reflect.DeepEqual(writer.events, expected) {
+		t.Errorf("Format(%v):\n%v\nwant:\n%v", input, writer.events, expected)
+	}
+}
+
+func TestFormatStream(t *testing.T) {
+	input := "{\"a\":1}\n[]\n{\"b\":}\n\"x\""
+	expected := "#1 {\n    \"a\": 1\n}\n#2 []\n#3 {\"b\":} (invalid character '}' looking for beginning of value at line 3, column 6)\n#4 \"x\""
+
+	writer := &stringWriter{}
+	New(jsonast.ParseStream([]byte(input)), writer, Options{}).Format()
+
+	if actual := writer.String(); actual != expected {
+		t.Errorf("Format(%v):\n%v\nwant:\n%v", input, actual, expected)
+	}
+
+	expected = "{\n    \"a\": 1\n}\n[]\n{\"b\":}\n\"x\""
+	writer = &stringWriter{}
+	New(jsonast.ParseStream([]byte(input)), writer, Options{Plain: true}).Format()
+	if actual := writer.String(); actual != expected {
+		t.Errorf("Format(%v) plain:\n%v\nwant:\n%v", input, actual, expected)
+	}
+}
+
+func TestFormatPlain(t *testing.T) {
+	input := `// config
+{
+  hex: 0x1F, // hex
+  big: -Infinity,
+  name: 'it\'s',
+  /* nothing yet */
+  empty: [/* none */],
+} // end`
+	expected := `{
+    "hex": 31,
+    "big": "-Infinity",
+    "name": "it's",
+    "empty": []
+}`
+
+	root, err := jsonast.ParseLenient([]byte(input))
+	if err != nil {
+		t.Fatalf("ParseLenient(%v): %v", input, err)
+	}
+	writer := &stringWriter{}
+	New(root, writer, Options{Plain: true}).Format()
+	if actual := writer.String(); actual != expected {
+		t.Errorf("Format(%v) plain:\n%v\nwant:\n%v", input, actual, expected)
+	}
+
+	// Dates and times and binary data become strings.
+	root, err = jsonast.ParseTOML([]byte("released = 1979-05-27T07:32:00Z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := &jsonast.Node{Type: jsonast.Bytes, Parent: root, Index: 1, Key: "data", KeyLiteral: "data", Value: strings.Repeat("\xff", 30)}
+	root.Children = append(root.Children, data)
+	expected = "{\n    \"released\": \"1979-05-27T07:32:00Z\",\n    \"data\": \"" + strings.Repeat("////", 10) + "\"\n}"
+	writer = &stringWriter{}
+	New(root, writer, Options{Plain: true}).Format()
+	if actual := writer.String(); actual != expected {
+		t.Errorf("Format plain: %q, want %q", actual, expected)
+	}
+}
+
+func TestFormatComments(t *testing.T) {
+	input := `// config
+{
+  /* compiler
+   * options */
+  compilerOptions: {
+    target: 'es5', // old browsers
+    lib: [
+      "dom", // browser
+    ],
+  },
+  empty: [
+    // nothing yet
+  ],
+} // end
+/* trailing */`
+	expected := `// config
+{
+    /* compiler
+     * options */
+    compilerOptions: {
+        target: 'es5', // old browsers
+        lib: [
+            "dom" // browser
+        ]
+    },
+    empty: [
+        // nothing yet
+    ]
+} // end
+/* trailing */`
+
+	root, err := jsonast.ParseLenient([]byte(input))
+	if err != nil {
+		t.Fatalf("ParseLenient(%v): %v", input, err)
+	}
+
+	writer := &stringWriter{}
+	New(root, writer, Options{}).Format()
+
+	if actual := writer.String(); actual != expected {
+		t.Errorf("Format(%v):\n%v\nwant:\n%v", input, actual, expected)
+	}
+}
+
+func TestFormatRecovered(t *testing.T) {
+	input := `{"a": [1 2`
+	expected := `RED{WHITE"a"RED:RED[YELLOW1ERROR(invalidcharacter'2'afterarrayelementatline1,column10)RED,YELLOW2ERROR]` +
+		`ERROR(unexpectedendofJSONinputatline1,column11)ERROR}ERROR(unexpectedendofJSONinputatline1,column11)`
+
+	root, _ := jsonast.ParseRecover([]byte(input))
+	colorMap := map[TokenType]string{DelimiterType: "RED", KeyType: "WHITE", NumberType: "YELLOW", ErrorType: "ERROR"}
+	writer := &stringWriter{colorMap: colorMap}
+	New(root, writer, Options{}).Format()
+
+	if actual := strings.Replace(writer.String(), "\n", "", -1); strings.Replace(actual, " ", "", -1) != expected {
+		t.Errorf("Format(%v): %v, want %v", input, actual, expected)
+	}
+}
+
+func TestFormatDuplicateKeys(t *testing.T) {
+	input := `{"a": 1, "b": 2, "a": 3}`
+	expected := `RED{DUP"a"RED:YELLOW1RED,WHITE"b"RED:YELLOW2RED,DUP"a"RED:YELLOW3RED}`
+
+	root := parse(t, input)
+	jsonast.FindDuplicates(root)
+	colorMap := map[TokenType]string{DelimiterType: "RED", KeyType: "WHITE", NumberType: "YELLOW", DuplicateKeyType: "DUP"}
+	writer := &stringWriter{colorMap: colorMap}
+	New(root, writer, Options{}).Format()
+
+	if actual := strings.Replace(writer.String(), "\n", "", -1); strings.Replace(actual, " ", "", -1) != expected {
+		t.Errorf("Format(%v): %v, want %v", input, actual, expected)
+	}
+}
+
+func TestFormatOptions(t *testing.T) {
+	input := `{"id": 1, "values": [1, 2, 3], "point": {"x": 1, "y": -2}, "long": [100, 200, 300, 400], "nested": [[1]]}`
+	examples := []struct {
+		opts     Options
+		expected string
+	}{
+		{Options{Indent: 2}, "{\n  \"id\": 1,\n  \"values\": [\n    1,\n    2,\n    3\n  ],\n  \"point\": {\n    \"x\": 1,\n    \"y\": -2\n  },\n" +
+			"  \"long\": [\n    100,\n    200,\n    300,\n    400\n  ],\n  \"nested\": [\n    [\n      1\n    ]\n  ]\n}"},
+		{Options{Tabs: true, InlineWidth: 36}, "{\n\t\"id\": 1,\n\t\"values\": [1, 2, 3],\n\t\"point\": {\"x\": 1, \"y\": -2},\n" +
+			"\t\"long\": [\n\t\t100,\n\t\t200,\n\t\t300,\n\t\t400\n\t],\n\t\"nested\": [\n\t\t[1]\n\t]\n}"},
+		{Options{InlineWidth: 35, AlignColons: true}, "{\n    \"id\"    : 1,\n    \"values\": [1, 2, 3],\n    \"point\" : {\"x\": 1, \"y\": -2},\n" +
+			"    \"long\"  : [100, 200, 300, 400],\n    \"nested\": [\n        [1]\n    ]\n}"},
+	}
+
+	for _, tt := range examples {
+		writer := &stringWriter{}
+		New(parse(t, input), writer, tt.opts).Format()
+		if actual := writer.String(); actual != tt.expected {
+			t.Errorf("Format(%+v):\n%v\nwant:\n%v", tt.opts, actual, tt.expected)
+		}
+	}
+}
+
+func TestFormatLines(t *testing.T) {
+	lenient, err := jsonast.ParseLenient([]byte("// a\n{b: [1, /* c\n d */ 2], // e\n a: {}, /* f */ }\n// g"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := []struct {
+		root *jsonast.Node
+		opts Options
+	}{
+		{parse(t, `{"b": [1, {"c": [], "d": [true, null]}], "a": {"e": "x"}, "f": 2}`), Options{}},
+		{parse(t, `{"b": [1, {"c": [], "d": [true, null]}], "a": {"e": "x"}, "f": 2}`), Options{SortKeys: true}},
+		{parse(t, `{"b": [1, {"c": [], "d": [true, null]}], "a": {"e": "x"}, "f": 2}`), Options{InlineWidth: 20, AlignColons: true}},
+		{jsonast.ParseStream([]byte("{\"a\": [1, 2]}\n// c\n[3, {\"b\": 4}]\n5")), Options{}},
+		{jsonast.ParseStream([]byte("{\"a\": [1, 2]}\n// c\n[3, {\"b\": 4}]\n5")), Options{InlineWidth: 12, Tabs: true}},
+		{lenient, Options{SortKeys: true}},
+	}
+
+	for _, tt := range inputs {
+		full := &stringWriter{}
+		formatter := New(tt.root, full, tt.opts)
+		formatter.Format()
+		lines := strings.Split(full.String(), "\n")
+
+		for from := 0; from < len(lines); from++ {
+			for to := from + 1; to <= len(lines); to++ {
+				w := &stringWriter{}
+				formatter.FormatLines(w, from, to)
+				if actual, expected := w.String(), strings.Join(lines[from:to], "\n"); actual != expected {
+					t.Errorf("FormatLines(%d, %d):\n%v\nwant:\n%v", from, to, actual, expected)
+				}
+			}
+		}
+	}
+}
+
+func TestFormatAppended(t *testing.T) {
+	for _, input := range []string{"", `{"a": [1, 2]}` + "\n3"} {
+		stream := jsonast.ParseStream([]byte(input))
+		index := &stringWriter{}
+		formatter := New(stream, index, Options{})
+		formatter.Format()
+
+		appended := jsonast.ParseStream([]byte(`4` + "\n" + `{"b": {"c": true}}`))
+		for _, record := range appended.Children {
+			record.Parent, record.Index = stream, len(stream.Children)
+			stream.Children = append(stream.Children, record)
+		}
+		formatter.FormatAppended()
+
+		full := &stringWriter{}
+		New(stream, full, Options{}).Format()
+		if index.String() != full.String() {
+			t.Errorf("FormatAppended(%q):\n%v\nwant:\n%v", input, index.String(), full.String())
+		}
+
+		lines := strings.Split(full.String(), "\n")
+		w := &stringWriter{}
+		formatter.FormatLines(w, 1, len(lines))
+		if expected := strings.Join(lines[1:], "\n"); w.String() != expected {
+			t.Errorf("FormatLines after FormatAppended(%q):\n%v\nwant:\n%v", input, w.String(), expected)
+		}
+	}
+}
+
+func TestFormatIndexed(t *testing.T) {
+	members := make([]string, 70)
+	for i := range members {
+		members[i] = fmt.Sprintf(`"k%d": [%d, {"z": "x", "y": []}]`, 70-i, i)
+	}
+	inputs := []string{
+		`{"b": [1, {"c": [], "d": [true, null]}], "a": {"e": "x"}, "f": 2}`,
+		"{" + strings.Join(members, ", ") + "}",
+	}
+	options := []Options{{}, {SortKeys: true}, {InlineWidth: 20, AlignColons: true}, {SortKeys: true, InlineWidth: 30}}
+
+	for _, input := range inputs {
+		for _, opts := range options {
+			full := &stringWriter{}
+			New(parse(t, input), full, opts).Format()
+			lines := strings.Split(full.String(), "\n")
+
+			root, err := jsonast.IndexJSON([]byte(input), nil)
+			if err != nil {
+				t.Fatalf("IndexJSON(%.40q): %v", input, err)
+			}
+			formatter := New(root, Discard, opts)
+			if n := formatter.Layout().Lines(); n != len(lines) {
+				t.Errorf("Layout(%.40q, %+v): %d lines, want %d", input, opts, n, len(lines))
+			}
+			for from := 0; from < len(lines); from += 7 {
+				to := min(from+9, len(lines))
+				w := &stringWriter{}
+				formatter.FormatLines(w, from, to)
+				if actual, expected := w.String(), strings.Join(lines[from:to], "\n"); actual != expected {
+					t.Errorf("FormatLines(%d, %d) %+v:\n%v\nwant:\n%v", from, to, opts, actual, expected)
+				}
+			}
+		}
+	}
+
+	// Streams with invalid records, which do not hold commas between them.
+	for _, input := range []string{"1\n,\n[2, [3]]\n", "{\"a\": [1, \"x\nnull]\n{\"b\": {}}", `1, /2.5e3, "x", true,`} {
+		full := &stringWriter{}
+		New(jsonast.ParseStream([]byte(input)), full, Options{}).Format()
+
+		stream, _ := jsonast.IndexStream([]byte(input), nil)
+		formatter := New(stream, Discard, Options{})
+		formatter.Layout()
+		w := &stringWriter{}
+		formatter.FormatLines(w, 0, math.MaxInt32)
+		if w.String() != full.String() {
+			t.Errorf("FormatLines(%q):\n%v\nwant:\n%v", input, w.String(), full.String())
+		}
+	}
+
+	stream, _ := jsonast.IndexStream([]byte(`{"a": [1, 2]}`+"\n3\n"), nil)
+	formatter := New(stream, Discard, Options{})
+	formatter.Layout()
+	jsonast.Append(stream, []byte("4\n"+`{"b": {"c": true}}`+"\n"))
+	formatter.FormatAppended()
+
+	full := &stringWriter{}
+	New(jsonast.ParseStream([]byte(`{"a": [1, 2]}`+"\n3\n4\n"+`{"b": {"c": true}}`)), full, Options{}).Format()
+	w := &stringWriter{}
+	formatter.FormatLines(w, 0, math.MaxInt32)
+	if w.String() != full.String() {
+		t.Errorf("FormatLines after Append:\n%v\nwant:\n%v", w.String(), full.String())
+	}
+}
+
+func TestFormatDateTime(t *testing.T) {
+	input := "released = 1979-05-27T07:32:00Z"
+	expected := `RED{WHITE"released"RED:CYAN1979-05-27T07:32:00ZRED}`
+
+	root, err := jsonast.ParseTOML([]byte(input))
+	if err != nil {
+		t.Fatalf("ParseTOML(%v): %v", input, err)
+	}
+
+	colorMap := map[TokenType]string{DelimiterType: "RED", KeyType: "WHITE", DateTimeType: "CYAN"}
+	writer := &stringWriter{colorMap: colorMap}
+	New(root, writer, Options{}).Format()
+
+	if actual := strings.Replace(writer.String(), "\n", "", -1); strings.Replace(actual, " ", "", -1) != expected {
+		t.Errorf("Format(%v): %v, want %v", input, actual, expected)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	long := strings.Repeat("\xff", 30)
+	examples := []struct {
+		value    string
+		base64   bool
+		expected string
+	}{
+		{"\xca\xfe", false, `h'cafe'`},
+		{"\xca\xfe", true, `b64'yv4='`},
+		{long, false, `h'` + strings.Repeat("ff", 24) + `…' (30 bytes)`},
+		{long, true, `b64'` + strings.Repeat("////", 8) + `…' (30 bytes)`},
+	}
+
+	for _, tt := range examples {
+		writer := &stringWriter{}
+		formatter := New(&jsonast.Node{Type: jsonast.Bytes, Value: tt.value}, writer, Options{BytesBase64: tt.base64})
+
+		formatter.Format()
+
+		if actual := writer.String(); actual != tt.expected {
+			t.Errorf("Format(%x): %v, want %v", tt.value, actual, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package jsontree
+
+import (
+	"sort"
+
+	"github.com/maxzender/jv/jsonast"
+)
+
+// nodeDocument is a Document whose node is known for every line, see
+// NewLazy.
+type nodeDocument struct {
+	nodes    []*jsonast.Node
+	render   func(from, to int) []Line
+	segments []Segment
+	lines    map[*jsonast.Node][2]int
+}
+
+// newNodeDocument finds the lines of the nodes and the segments, the first
+// and last line of every container spanning multiple lines.
+func newNodeDocument(nodes []*jsonast.Node, render func(from, to int) []Line) *nodeDocument {
+	doc := &nodeDocument{nodes: nodes, render: render, lines: map[*jsonast.Node][2]int{}}
+	for ln, n := range nodes {
+		if n == nil {
+			continue
+		}
+
+		lines, ok := doc.lines[n]
+		if !ok {
+			lines[0] = ln
+		}
+		lines[1] = ln
+		doc.lines[n] = lines
+	}
+
+	for n, lines := range doc.lines {
+		if n.IsContainer() && lines[1] > lines[0] {
+			doc.segments = append(doc.segments, Segment{lines[0], lines[1], n.Depth()})
+		}
+	}
+	sort.Slice(doc.segments, func(i, j int) bool {
+		return doc.segments[i].Start < doc.segments[j].Start
+	})
+	return doc
+}
+
+func (d *nodeDocument) Len() int {
+	return len(d.nodes)
+}
+
+func (d *nodeDocument) Render(from, to int) []Line {
+	return d.render(from, to)
+}
+
+func (d *nodeDocument) Node(ln int) *jsonast.Node {
+	if ln < 0 || ln >= len(d.nodes) {
+		return nil
+	}
+	return d.nodes[ln]
+}
+
+func (d *nodeDocument) Line(n *jsonast.Node, last bool) (int, bool) {
+	lines, ok := d.lines[n]
+	if last {
+		return lines[1], ok
+	}
+	return lines[0], ok
+}
+
+func (d *nodeDocument) Segments(from, to int, fn func(Segment) bool) {
+	end := -1
+	i := sort.Search(len(d.segments), func(i int) bool { return d.segments[i].Start >= from })
+	for ; i < len(d.segments) && d.segments[i].Start < to; i++ {
+		// Segments are nested, so those starting within the last one
+		// are skipped.
+		if s := d.segments[i]; s.Start > end {
+			if !fn(s) {
+				return
+			}
+			end = s.End
+		}
+	}
+}
+
+// Children is not supported, there is a node for every line anyway.
+func (d *nodeDocument) Children(int) (int, bool) {
+	return 0, false
+}
+
+func (d *nodeDocument) Child(int, int) int {
+	return 0
+}
+
+func (d *nodeDocument) ChildAt(int, int) int {
+	return 0
+}
@@ -1,18 +1,100 @@
 package jsontree
 
 import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
 	"unicode"
 
+	"github.com/maxzender/jv/jsonast"
 	"github.com/nsf/termbox-go"
 )
 
+// Document is a formatted document shown by a tree. Its lines are rendered
+// on demand and only looked up where needed, so huge documents are cheap.
+type Document interface {
+	// Len returns the number of lines of the document.
+	Len() int
+
+	// Render returns the lines from up to to.
+	Render(from, to int) []Line
+
+	// Node returns the node shown on line ln: the value starting on it, or
+	// the container for a line closing one.
+	Node(ln int) *jsonast.Node
+
+	// Line returns the first line of n, or its last line if last is set.
+	Line(n *jsonast.Node, last bool) (int, bool)
+
+	// Segments calls fn for the outermost segments starting on the lines
+	// from up to to, in order, until fn returns false.
+	Segments(from, to int, fn func(Segment) bool)
+
+	// Children returns the number of children of the container starting
+	// on line ln, or of the records of a stream if ln is -1. ok is false
+	// if the document does not support looking them up with Child and
+	// ChildAt.
+	Children(ln int) (count int, ok bool)
+
+	// Child returns the line the child with index i of the container
+	// starting on line ln starts on, ChildAt the index of the child shown
+	// on line actualLn.
+	Child(ln, i int) int
+	ChildAt(ln, actualLn int) int
+}
+
+// Segment is a container taking several lines, from Start to End, which
+// closes it. Depth is its nesting level as returned by jsonast.Node.Depth.
+type Segment struct {
+	Start, End, Depth int
+}
+
 type JsonTree struct {
-	lines         []Line
-	expandedLines map[int]struct{}
-	lineMap       map[int]int
-	segments      map[int]int
+	doc   Document
+	cache map[int][]Line
+
+	// The segments shown expanded are the ones toggled so, or else the
+	// ones expanded or collapsed by the last of ranges covering them, or
+	// else the ones nested less than depth levels deep. first is set once
+	// the top-level value has been expanded.
+	depth   int
+	toggled map[int]bool
+	ranges  []segmentRange
+	first   bool
+
+	// runs are the lines shown, in order, of the length lines of the
+	// document.
+	runs   []run
+	length int
 }
 
+// segmentRange expands or collapses the segments starting on the lines
+// from start up to end.
+type segmentRange struct {
+	start, end int
+	expand     bool
+}
+
+// run is a part of the lines shown: count lines from the virtual line on,
+// which are the lines from start on. If parent is not noParent, they are
+// the first lines of the children of the container starting on line parent
+// from the child with index child on, the first of which starts on start.
+type run struct {
+	virtual, start, count int
+	parent, child         int
+}
+
+// noParent is the parent of runs of consecutive lines.
+const noParent = -2
+
+// blockSize is the number of lines rendered at once, maxCachedBlocks the
+// number of rendered blocks kept.
+const (
+	blockSize       = 64
+	maxCachedBlocks = 256
+)
+
 type Char struct {
 	Val   rune
 	Color termbox.Attribute
@@ -20,53 +102,402 @@ type Char struct {
 
 type Line []Char
 
-func New(lines []Line) *JsonTree {
+// String returns the text of l.
+func (l Line) String() string {
+	runes := make([]rune, len(l))
+	for i, c := range l {
+		runes[i] = c.Val
+	}
+	return string(runes)
+}
+
+// New creates a tree of the given lines. nodes holds the document node
+// each line belongs to: the value starting on it, or the container for a
+// line closing one.
+func New(lines []Line, nodes []*jsonast.Node) *JsonTree {
+	return NewLazy(nodes, func(from, to int) []Line {
+		return lines[from:to]
+	})
+}
+
+// NewLazy creates a tree whose lines are rendered on demand: render
+// returns the lines from up to to. Only the nodes are needed for all
+// lines.
+func NewLazy(nodes []*jsonast.Node, render func(from, to int) []Line) *JsonTree {
+	return NewDocument(newNodeDocument(nodes, render))
+}
+
+// NewDocument creates a tree showing doc with its top-level value expanded.
+func NewDocument(doc Document) *JsonTree {
 	model := &JsonTree{
-		lines:         lines,
-		expandedLines: map[int]struct{}{},
-		lineMap:       make(map[int]int),
-		segments:      parseSegments(lines),
+		doc:     doc,
+		cache:   map[int][]Line{},
+		toggled: map[int]bool{},
 	}
-	model.recalculateLineMap()
-	model.ToggleLine(0)
+	model.expandFirstSegment()
+	model.update()
 
 	return model
 }
 
+// expandFirstSegment expands the top-level value, which does not have to
+// start on the first line, e.g. when it is preceded by comments.
+func (t *JsonTree) expandFirstSegment() {
+	t.doc.Segments(0, t.doc.Len(), func(s Segment) bool {
+		t.toggled[s.Start], t.first = true, true
+		return false
+	})
+}
+
 func (t *JsonTree) ToggleLine(virtualLn int) {
-	actualLn := t.lineMap[virtualLn]
-	if !t.isBeginningOfSegment(actualLn) {
+	actualLn, ok := t.actualLine(virtualLn)
+	if !ok {
 		return
 	}
+	if s, ok := t.segment(actualLn); ok {
+		t.toggled[s.Start] = !t.isExpanded(s)
+		t.update()
+	}
+}
+
+// ExpandAll expands every segment. Like the other methods changing several
+// segments at once, it returns the line now showing the given line, or the
+// collapsed segment hiding it, so the cursor can stay where it is.
+func (t *JsonTree) ExpandAll(virtualLn int) int {
+	return t.ExpandToDepth(math.MaxInt32, virtualLn)
+}
+
+// CollapseAll collapses every segment.
+func (t *JsonTree) CollapseAll(virtualLn int) int {
+	return t.ExpandToDepth(0, virtualLn)
+}
+
+// ExpandRecursively expands the segment starting on the given line and
+// all segments within it.
+func (t *JsonTree) ExpandRecursively(virtualLn int) int {
+	return t.changeWithin(virtualLn, true)
+}
 
-	if t.isExpanded(actualLn) {
-		delete(t.expandedLines, actualLn)
-	} else {
-		t.expandedLines[actualLn] = struct{}{}
+// CollapseRecursively collapses the segment starting on the given line and
+// all segments within it, so they are still collapsed when it is expanded
+// again.
+func (t *JsonTree) CollapseRecursively(virtualLn int) int {
+	return t.changeWithin(virtualLn, false)
+}
+
+// ExpandToDepth expands the segments of values nested less than depth
+// levels deep and collapses all others, e.g. only the top-level value for
+// depth 1.
+func (t *JsonTree) ExpandToDepth(depth, virtualLn int) int {
+	return t.change(virtualLn, func() {
+		t.depth, t.toggled, t.ranges = depth, map[int]bool{}, nil
+	})
+}
+
+// CollapseParent collapses the innermost segment enclosing the given line,
+// e.g. the container of a value, and returns the line of its beginning.
+func (t *JsonTree) CollapseParent(virtualLn int) int {
+	actualLn, ok := t.actualLine(virtualLn)
+	if !ok {
+		return virtualLn
+	}
+
+	segments := t.enclosing(actualLn)
+	if len(segments) == 0 {
+		return virtualLn
 	}
-	t.recalculateLineMap()
+
+	t.toggled[segments[0].Start] = false
+	t.update()
+	return t.virtualLine(segments[0].Start)
+}
+
+func (t *JsonTree) changeWithin(virtualLn int, expand bool) int {
+	actualLn, ok := t.actualLine(virtualLn)
+	if !ok {
+		return virtualLn
+	}
+	s, ok := t.segment(actualLn)
+	if !ok {
+		return virtualLn
+	}
+
+	return t.change(virtualLn, func() {
+		for startLn := range t.toggled {
+			if s.Start <= startLn && startLn < s.End {
+				delete(t.toggled, startLn)
+			}
+		}
+		ranges := t.ranges[:0]
+		for _, r := range t.ranges {
+			if r.start < s.Start || r.end > s.End {
+				ranges = append(ranges, r)
+			}
+		}
+		t.ranges = append(ranges, segmentRange{s.Start, s.End, expand})
+	})
+}
+
+// change changes the state of segments with apply and returns the line now
+// showing the given line.
+func (t *JsonTree) change(virtualLn int, apply func()) int {
+	actualLn, ok := t.actualLine(virtualLn)
+	if !ok {
+		return virtualLn
+	}
+
+	apply()
+	t.update()
+	return t.virtualLine(actualLn)
 }
 
 func (t *JsonTree) Line(virtualLn int) Line {
-	actualLn, ok := t.lineMap[virtualLn]
-	if ok {
-		ln := t.lines[actualLn]
-		if t.isBeginningOfSegment(actualLn) && !t.isExpanded(actualLn) {
-			ln = t.lineWithDots(actualLn)
+	actualLn, ok := t.actualLine(virtualLn)
+	if !ok {
+		return nil
+	}
+
+	if s, ok := t.segment(actualLn); ok && !t.isExpanded(s) {
+		return t.lineWithDots(s)
+	}
+	return t.line(actualLn)
+}
+
+// Node returns the document node shown on the given line.
+func (t *JsonTree) Node(virtualLn int) *jsonast.Node {
+	if actualLn, ok := t.actualLine(virtualLn); ok {
+		return t.doc.Node(actualLn)
+	}
+
+	return nil
+}
+
+// Len returns the number of lines shown.
+func (t *JsonTree) Len() int {
+	if len(t.runs) == 0 {
+		return 0
+	}
+	last := t.runs[len(t.runs)-1]
+	return last.virtual + last.count
+}
+
+// Position describes where the given line is among the lines shown, e.g.
+// 7/20 35%, counting from 1.
+func (t *JsonTree) Position(virtualLn int) string {
+	line, lines := virtualLn+1, t.Len()
+	return fmt.Sprintf("%d/%d %d%%", line, lines, 100*line/max(lines, 1))
+}
+
+// Grow shows the lines added to the document since the tree was created,
+// e.g. for records appended to a stream. The last line may have changed as
+// well. Lines that are already shown keep their folding.
+func (t *JsonTree) Grow() {
+	from := max(0, t.length-1) / blockSize
+	for block := range t.cache {
+		if block >= from {
+			delete(t.cache, block)
+		}
+	}
+	if !t.first {
+		t.expandFirstSegment()
+	}
+	t.update()
+}
+
+// Folding is the state of the segments of a tree and the value the cursor
+// is on, held by the paths of the nodes rather than by line, so it can be
+// carried over to a tree of the same document formatted differently, or of
+// a copy of it such as the more complete one shown while loading it, see
+// SetFolding.
+type Folding struct {
+	depth   int
+	first   bool
+	toggled []foldedNode
+	ranges  []foldedNode
+
+	cursor []int
+	last   bool
+}
+
+// foldedNode is a segment toggled, or a range of segments changed, by the
+// container at path starting it.
+type foldedNode struct {
+	path   []int
+	expand bool
+}
+
+// Folding returns the state of the segments and the value shown on the
+// given line, which is on its last line if last is set.
+func (t *JsonTree) Folding(virtualLn int) Folding {
+	f := Folding{depth: t.depth, first: t.first}
+	for startLn, expand := range t.toggled {
+		if n := t.doc.Node(startLn); n != nil {
+			f.toggled = append(f.toggled, foldedNode{path(n), expand})
+		}
+	}
+	for _, r := range t.ranges {
+		if n := t.doc.Node(r.start); n != nil {
+			f.ranges = append(f.ranges, foldedNode{path(n), r.expand})
+		}
+	}
+
+	if actualLn, ok := t.actualLine(virtualLn); ok {
+		if n := t.doc.Node(actualLn); n != nil {
+			start, _ := t.doc.Line(n, false)
+			f.cursor, f.last = path(n), actualLn != start
+		}
+	}
+	return f
+}
+
+// SetFolding applies f, taken from a tree of the same document, to the
+// segments of the same values and returns the line now showing the value
+// the cursor was on, or the collapsed segment hiding it.
+func (t *JsonTree) SetFolding(f Folding) int {
+	top := t.top()
+	if top == nil {
+		return 0
+	}
+
+	if f.first {
+		t.depth, t.first, t.toggled, t.ranges = f.depth, true, map[int]bool{}, nil
+		for _, folded := range f.toggled {
+			if startLn, ok := t.lineAt(top, folded.path, false); ok {
+				t.toggled[startLn] = folded.expand
+			}
 		}
-		return ln
+		for _, folded := range f.ranges {
+			start, ok := t.lineAt(top, folded.path, false)
+			end, _ := t.lineAt(top, folded.path, true)
+			if ok {
+				t.ranges = append(t.ranges, segmentRange{start, end, folded.expand})
+			}
+		}
+		t.update()
+	}
+
+	if f.cursor == nil {
+		return 0
+	}
+	actualLn, ok := t.lineAt(top, f.cursor, f.last)
+	if !ok {
+		return 0
 	}
+	return max(0, t.virtualLine(actualLn))
+}
 
+// top returns the top-level value, or the stream, of the document.
+func (t *JsonTree) top() *jsonast.Node {
+	for ln := 0; ln < t.doc.Len(); ln++ {
+		if n := t.doc.Node(ln); n != nil {
+			for n.Parent != nil {
+				n = n.Parent
+			}
+			return n
+		}
+	}
 	return nil
 }
 
-func (t *JsonTree) lineWithDots(actualLn int) Line {
-	ln := t.lines[actualLn]
+// path returns the indexes of n and its ancestors within their parents, up
+// to the top-level value, which locate n in copies of the document.
+func path(n *jsonast.Node) []int {
+	p := []int{}
+	for ; n.Parent != nil; n = n.Parent {
+		p = append(p, n.Index)
+	}
+	return p
+}
+
+// lineAt returns the first line of the node at the given path below top,
+// or its last line if last is set. ok is false if there is no such node.
+func (t *JsonTree) lineAt(top *jsonast.Node, path []int, last bool) (int, bool) {
+	n := top
+	for i := len(path) - 1; i >= 0 && n != nil; i-- {
+		n = n.Child(path[i])
+	}
+	if n == nil {
+		return 0, false
+	}
+	return t.doc.Line(n, last)
+}
+
+// Reveal expands the segments hiding the first line of n, or its last line
+// if last is set, and returns the virtual line number of that line.
+func (t *JsonTree) Reveal(n *jsonast.Node, last bool) (int, bool) {
+	actualLn, ok := t.doc.Line(n, last)
+	if !ok {
+		return 0, false
+	}
+
+	return t.RevealLine(actualLn), true
+}
+
+// RevealLine expands the segments hiding the given line of the fully
+// expanded tree and returns the virtual line number of that line.
+func (t *JsonTree) RevealLine(actualLn int) int {
+	for _, s := range t.enclosing(actualLn) {
+		t.toggled[s.Start] = true
+	}
+	t.update()
+
+	return t.virtualLine(actualLn)
+}
+
+// ActualLine returns the line of the fully expanded tree shown on the given
+// line, e.g. to compare it with the lines returned by Find.
+func (t *JsonTree) ActualLine(virtualLn int) (int, bool) {
+	return t.actualLine(virtualLn)
+}
+
+// Lines returns the number of lines of the fully expanded tree.
+func (t *JsonTree) Lines() int {
+	return t.doc.Len()
+}
+
+// Find returns the lines from up to to of the fully expanded tree on which
+// re matches the text shown, in order. Matching the rendered lines rather
+// than the nodes finds exactly what is highlighted, also within the values
+// of containers written on one line. Huge trees are searched a part at a
+// time, see Lines.
+func (t *JsonTree) Find(re *regexp.Regexp, from, to int) []int {
+	var lines []int
+	to = min(to, t.doc.Len())
+	for ; from < to; from += blockSize {
+		// The blocks are rendered without caching them, so searching
+		// does not evict the ones shown.
+		for i, line := range t.doc.Render(from, min(to, from+blockSize)) {
+			if re.MatchString(line.String()) {
+				lines = append(lines, from+i)
+			}
+		}
+	}
+	return lines
+}
+
+// line returns the given actual line, rendering the block of lines around
+// it if needed. Rendered blocks are kept until there are too many.
+func (t *JsonTree) line(actualLn int) Line {
+	block := actualLn / blockSize
+	lines, ok := t.cache[block]
+	if !ok {
+		if len(t.cache) >= maxCachedBlocks {
+			t.cache = map[int][]Line{}
+		}
+		lines = t.doc.Render(block*blockSize, min(t.doc.Len(), (block+1)*blockSize))
+		t.cache[block] = lines
+	}
+
+	return lines[actualLn-block*blockSize]
+}
+
+func (t *JsonTree) lineWithDots(s Segment) Line {
+	ln := append(Line(nil), t.line(s.Start)...)
 
 	lastChar := ln[len(ln)-1]
 	ln = append(ln, Char{'…', lastChar.Color})
 
-	matchingBrace := t.lines[t.segments[actualLn]]
+	matchingBrace := t.line(s.End)
 	for _, c := range matchingBrace {
 		if !unicode.IsSpace(c.Val) {
 			ln = append(ln, c)
@@ -76,52 +507,218 @@ func (t *JsonTree) lineWithDots(actualLn int) Line {
 	return ln
 }
 
-func (t *JsonTree) isExpanded(actualLn int) bool {
-	_, isExpanded := t.expandedLines[actualLn]
-	return isExpanded
+// segment returns the segment starting on the given line.
+func (t *JsonTree) segment(actualLn int) (Segment, bool) {
+	var segment Segment
+	found := false
+	t.doc.Segments(actualLn, actualLn+1, func(s Segment) bool {
+		segment, found = s, true
+		return false
+	})
+	return segment, found
 }
 
-func (t *JsonTree) isBeginningOfSegment(actualLn int) bool {
-	_, ok := t.segments[actualLn]
-	return ok
+// enclosing returns the segments enclosing the given line from the
+// innermost one on: the ones of the containers of the value shown on it,
+// and of the value itself on a line closing it.
+func (t *JsonTree) enclosing(actualLn int) []Segment {
+	var segments []Segment
+	for n := t.doc.Node(actualLn); n != nil && n.Type != jsonast.Stream; n = n.Parent {
+		start, ok := t.doc.Line(n, false)
+		end, _ := t.doc.Line(n, true)
+		if ok && start < actualLn && actualLn <= end {
+			segments = append(segments, Segment{start, end, n.Depth()})
+		}
+	}
+	return segments
 }
 
-func (t *JsonTree) recalculateLineMap() {
-	t.lineMap = make(map[int]int)
-	skipTill := 0
-	virtualLn := 0
-	for actualLn := range t.lines {
-		if actualLn < skipTill {
-			continue
+func (t *JsonTree) isExpanded(s Segment) bool {
+	if expand, ok := t.toggled[s.Start]; ok {
+		return expand
+	}
+	for i := len(t.ranges) - 1; i >= 0; i-- {
+		if r := t.ranges[i]; r.start <= s.Start && s.Start < r.end {
+			return r.expand
 		}
+	}
+	return s.Depth < t.depth
+}
 
-		if t.isBeginningOfSegment(actualLn) && !t.isExpanded(actualLn) {
-			skipTill = t.segments[actualLn] + 1
+// actualLine maps a line on screen to the line of the fully expanded tree.
+func (t *JsonTree) actualLine(virtualLn int) (int, bool) {
+	if virtualLn < 0 || virtualLn >= t.Len() {
+		return 0, false
+	}
+
+	i := sort.Search(len(t.runs), func(i int) bool { return t.runs[i].virtual > virtualLn }) - 1
+	r := t.runs[i]
+	if r.parent == noParent {
+		return r.start + virtualLn - r.virtual, true
+	}
+	return t.doc.Child(r.parent, r.child+virtualLn-r.virtual), true
+}
+
+// virtualLine returns the line showing the given line of the fully
+// expanded tree, or the collapsed segment hiding it: the last line shown
+// up to it.
+func (t *JsonTree) virtualLine(actualLn int) int {
+	i := sort.Search(len(t.runs), func(i int) bool { return t.runs[i].start > actualLn }) - 1
+	if i < 0 {
+		return -1
+	}
+
+	r := t.runs[i]
+	offset := actualLn - r.start
+	if r.parent != noParent {
+		offset = t.doc.ChildAt(r.parent, actualLn) - r.child
+	}
+	return r.virtual + min(offset, r.count-1)
+}
+
+// update finds the lines shown after segments were expanded or collapsed.
+func (t *JsonTree) update() {
+	t.length = t.doc.Len()
+	if t.allExpanded() {
+		t.runs = addRun(t.runs[:0], 0, t.length)
+		return
+	}
+	t.runs = t.visible(t.runs[:0], -1, 0, t.length, -1)
+}
+
+// allExpanded reports whether no segment is collapsed, so every line is
+// shown.
+func (t *JsonTree) allExpanded() bool {
+	if t.depth < math.MaxInt32 {
+		return false
+	}
+	for _, r := range t.ranges {
+		if !r.expand {
+			return false
 		}
+	}
+	for _, expand := range t.toggled {
+		if !expand {
+			return false
+		}
+	}
+	return true
+}
 
-		t.lineMap[virtualLn] = actualLn
-		virtualLn++
+// visible appends the runs of lines shown of the lines from up to to to
+// runs. The lines are within the container starting on line parent, which
+// is depth levels deep, or are the top-level lines if parent is -1.
+func (t *JsonTree) visible(runs []run, parent, from, to, depth int) []run {
+	if expanded, ok := t.expandedChildren(parent, from, to, depth+1); ok {
+		if count, ok := t.doc.Children(parent); ok && count > 0 {
+			return t.visibleChildren(runs, parent, count, expanded)
+		}
 	}
+
+	ln := from
+	t.doc.Segments(from, to, func(s Segment) bool {
+		runs = addRun(runs, ln, s.Start+1)
+		if t.isExpanded(s) {
+			runs = t.visible(runs, s.Start, s.Start+1, s.End, s.Depth)
+			ln = s.End
+		} else {
+			ln = s.End + 1
+		}
+		return true
+	})
+	return addRun(runs, ln, to)
 }
 
-func parseSegments(lines []Line) map[int]int {
-	resultSegments := make(map[int]int)
-	bracketBalances := make(map[int]int)
-	var bal int
-	for num, line := range lines {
-		for _, c := range line {
-			switch c.Val {
-			case '{', '[':
-				bal++
-				bracketBalances[bal] = num
-			case '}', ']':
-				if startLn := bracketBalances[bal]; startLn != num {
-					resultSegments[startLn] = num
-				}
-				bal--
-			}
+// expandedChildren returns the segments expanded among the children of
+// the container starting on line parent, which hold the lines from up to
+// to and are depth levels deep, if they are few. ok is false if they are
+// expanded by depth or by a range, then there may be many.
+func (t *JsonTree) expandedChildren(parent, from, to, depth int) ([]Segment, bool) {
+	if depth < t.depth {
+		return nil, false
+	}
+	for _, r := range t.ranges {
+		if r.expand && r.start < to && from < r.end {
+			return nil, false
+		}
+	}
+
+	var segments []Segment
+	for startLn, expand := range t.toggled {
+		if !expand || startLn < from || startLn >= to || t.doc.Child(parent, t.doc.ChildAt(parent, startLn)) != startLn {
+			continue
+		}
+		if s, ok := t.segment(startLn); ok {
+			segments = append(segments, s)
 		}
 	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Start < segments[j].Start })
+	return segments, true
+}
+
+// visibleChildren appends the runs of lines shown of the count children of
+// the container starting on line parent to runs, one line each except for
+// the expanded segments.
+func (t *JsonTree) visibleChildren(runs []run, parent, count int, expanded []Segment) []run {
+	i := 0
+	for _, s := range expanded {
+		k := t.doc.ChildAt(parent, s.Start)
+		runs = addChildren(runs, parent, i, k, t.doc.Child(parent, i))
+		runs = addRun(runs, s.Start, s.Start+1)
+		runs = t.visible(runs, s.Start, s.Start+1, s.End, s.Depth)
+		runs = addRun(runs, s.End, s.End+1)
+		i = k + 1
+	}
+	if i < count {
+		runs = addChildren(runs, parent, i, count, t.doc.Child(parent, i))
+	}
+	return runs
+}
+
+// addRun appends the lines from up to to to runs.
+func addRun(runs []run, from, to int) []run {
+	if from >= to {
+		return runs
+	}
 
-	return resultSegments
+	virtual := 0
+	if len(runs) > 0 {
+		last := &runs[len(runs)-1]
+		if last.parent == noParent && last.start+last.count == from {
+			last.count += to - from
+			return runs
+		}
+		virtual = last.virtual + last.count
+	}
+	return append(runs, run{virtual, from, to - from, noParent, 0})
+}
+
+// addChildren appends the first lines of the children from index i up to k
+// of the container starting on line parent to runs, the first of which
+// starts on line start.
+func addChildren(runs []run, parent, i, k, start int) []run {
+	if i >= k {
+		return runs
+	}
+
+	virtual := 0
+	if len(runs) > 0 {
+		last := runs[len(runs)-1]
+		virtual = last.virtual + last.count
+	}
+	return append(runs, run{virtual, start, k - i, parent, i})
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
 }
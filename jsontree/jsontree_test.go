@@ -2,8 +2,12 @@ package jsontree
 
 import (
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
 	"testing"
+
+	"github.com/maxzender/jv/jsonast"
 )
 
 var sampleJson = createLinesFromString(`{
@@ -13,8 +17,10 @@ var sampleJson = createLinesFromString(`{
     }
 }`)
 
+var sampleNodes = createNodes(`{"foo": 0, "bar": {"baz": true}}`, "", "0", "1", "1.0", "1", "")
+
 func TestToggleLine(t *testing.T) {
-	tree := New(sampleJson)
+	tree := New(sampleJson, sampleNodes)
 
 	tree.ToggleLine(2)
 	actual := tree.Line(3)
@@ -42,7 +48,7 @@ var sampleJsonWithEmptyObject = createLinesFromString(`{
 }`)
 
 func TestEmptyObjects(t *testing.T) {
-	tree := New(sampleJsonWithEmptyObject)
+	tree := New(sampleJsonWithEmptyObject, createNodes(`{"foo": {}, "bar": {"baz": 0}}`, "", "0", "1", "1.0", "1", ""))
 
 	actual := tree.Line(1)
 	expected := createLinesFromString(`    "foo": {},`)[0]
@@ -59,6 +65,230 @@ func TestEmptyObjects(t *testing.T) {
 	}
 }
 
+func TestNode(t *testing.T) {
+	tree := New(sampleJson, sampleNodes)
+
+	if actual := tree.Node(3).Path(); actual != "." {
+		t.Errorf("Node: %v, want .", actual)
+	}
+
+	tree.ToggleLine(2)
+	if actual := tree.Node(3).Path(); actual != ".bar.baz" {
+		t.Errorf("Node: %v, want .bar.baz", actual)
+	}
+
+	if actual := tree.Node(6); actual != nil {
+		t.Errorf("Node: %v, want nil", actual)
+	}
+}
+
+func TestReveal(t *testing.T) {
+	tree := New(sampleJson, sampleNodes)
+	tree.ToggleLine(0)
+
+	baz := sampleNodes[3]
+	if ln, ok := tree.Reveal(baz, false); !ok || ln != 3 || tree.Node(ln) != baz {
+		t.Errorf("Reveal(.bar.baz): %v, %v, want 3, true", ln, ok)
+	}
+
+	tree.ToggleLine(2)
+	tree.ToggleLine(0)
+	if ln, ok := tree.Reveal(sampleNodes[2], true); !ok || ln != 4 {
+		t.Errorf("Reveal(.bar, last): %v, %v, want 4, true", ln, ok)
+	}
+
+	if _, ok := tree.Reveal(&jsonast.Node{}, false); ok {
+		t.Errorf("Reveal(unknown node): found")
+	}
+}
+
+func TestExpandAndCollapse(t *testing.T) {
+	tree := New(sampleJson, sampleNodes)
+
+	if ln := tree.ExpandAll(2); ln != 2 || tree.Len() != 6 {
+		t.Errorf("ExpandAll: line %v, %v lines, want 2, 6", ln, tree.Len())
+	}
+	if ln := tree.CollapseAll(3); ln != 0 || tree.Len() != 1 {
+		t.Errorf("CollapseAll: line %v, %v lines, want 0, 1", ln, tree.Len())
+	}
+	if ln := tree.ExpandRecursively(0); ln != 0 || tree.Len() != 6 {
+		t.Errorf("ExpandRecursively: line %v, %v lines, want 0, 6", ln, tree.Len())
+	}
+
+	tree.CollapseRecursively(2)
+	if tree.Len() != 4 {
+		t.Errorf("CollapseRecursively: %v lines, want 4", tree.Len())
+	}
+	if ln := tree.CollapseRecursively(1); ln != 1 || tree.Len() != 4 {
+		t.Errorf("CollapseRecursively without segment: line %v, %v lines, want 1, 4", ln, tree.Len())
+	}
+
+	for _, tt := range []struct{ depth, cursor, line, lines int }{
+		{0, 3, 0, 1},
+		{1, 0, 0, 4},
+		{2, 2, 2, 6},
+		{1, 3, 2, 4},
+	} {
+		if ln := tree.ExpandToDepth(tt.depth, tt.cursor); ln != tt.line || tree.Len() != tt.lines {
+			t.Errorf("ExpandToDepth(%v, %v): line %v, %v lines, want %v, %v", tt.depth, tt.cursor, ln, tree.Len(), tt.line, tt.lines)
+		}
+	}
+}
+
+func TestPosition(t *testing.T) {
+	tree := New(sampleJson, sampleNodes)
+	tree.ToggleLine(2)
+
+	for _, tt := range []struct {
+		line     int
+		expected string
+	}{
+		{0, "1/6 16%"},
+		{3, "4/6 66%"},
+		{5, "6/6 100%"},
+	} {
+		if actual := tree.Position(tt.line); actual != tt.expected {
+			t.Errorf("Position(%v): %v, want %v", tt.line, actual, tt.expected)
+		}
+	}
+}
+
+func TestCollapseParent(t *testing.T) {
+	tree := New(sampleJson, sampleNodes)
+	tree.ExpandAll(0)
+
+	for _, tt := range []struct{ cursor, line, lines int }{
+		{3, 2, 4},
+		{2, 0, 1},
+		{0, 0, 1},
+	} {
+		if ln := tree.CollapseParent(tt.cursor); ln != tt.line || tree.Len() != tt.lines {
+			t.Errorf("CollapseParent(%v): line %v, %v lines, want %v, %v", tt.cursor, ln, tree.Len(), tt.line, tt.lines)
+		}
+	}
+
+	tree.ExpandAll(0)
+	if ln := tree.CollapseParent(4); ln != 2 || tree.Len() != 4 {
+		t.Errorf("CollapseParent(closing line): line %v, %v lines, want 2, 4", ln, tree.Len())
+	}
+}
+
+func TestFind(t *testing.T) {
+	tree := New(sampleJson, sampleNodes)
+
+	lines := tree.Find(regexp.MustCompile(`ba|true`), 0, tree.Lines())
+	if expected := []int{2, 3}; !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Find: %v, want %v", lines, expected)
+	}
+	if lines := tree.Find(regexp.MustCompile(`ba|true`), 3, 10); !reflect.DeepEqual(lines, []int{3}) {
+		t.Errorf("Find from line 3: %v, want [3]", lines)
+	}
+
+	// The children of a container written on one line are found on it,
+	// just like they are highlighted.
+	inline := New(createLinesFromString(`{
+    "foo": 0,
+    "bar": {"baz": true}
+}`), createNodes(`{"foo": 0, "bar": {"baz": true}}`, "", "0", "1", ""))
+	if lines := inline.Find(regexp.MustCompile(`true`), 0, inline.Lines()); !reflect.DeepEqual(lines, []int{2}) {
+		t.Errorf("Find in inline container: %v, want [2]", lines)
+	}
+
+	if ln := tree.RevealLine(3); ln != 3 || tree.Node(ln) != sampleNodes[3] {
+		t.Errorf("RevealLine(3): %v, want 3", ln)
+	}
+	if actualLn, ok := tree.ActualLine(3); !ok || actualLn != 3 {
+		t.Errorf("ActualLine(3): %v, %v, want 3, true", actualLn, ok)
+	}
+}
+
+func TestGrow(t *testing.T) {
+	lines := createLinesFromString(`{
+    "foo": 0`)
+	render := func(from, to int) []Line {
+		return lines[from:to]
+	}
+	doc := newNodeDocument(sampleNodes[:2], render)
+	tree := NewDocument(doc)
+	if tree.Len() != 2 {
+		t.Fatalf("Len: %v, want 2", tree.Len())
+	}
+
+	lines = sampleJson
+	*doc = *newNodeDocument(sampleNodes, render)
+	tree.Grow()
+	if tree.Len() != 4 {
+		t.Errorf("Len after Grow: %v, want 4", tree.Len())
+	}
+	if actual, expected := tree.Line(2), createLinesFromString(`    "bar": {…}`)[0]; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Line: %v, want %v", actual, expected)
+	}
+}
+
+func TestSetFolding(t *testing.T) {
+	sorted := createLinesFromString(`{
+    "bar": {
+        "baz": true
+    },
+    "foo": 0
+}`)
+	root, foo, bar, baz := sampleNodes[0], sampleNodes[1], sampleNodes[2], sampleNodes[3]
+	sortedNodes := []*jsonast.Node{root, bar, baz, bar, foo, root}
+
+	for _, tt := range []struct {
+		toggle       bool
+		cursor, line int
+		lines        int
+	}{
+		{true, 3, 2, 6},
+		{true, 4, 3, 6},
+		{false, 1, 2, 4},
+	} {
+		tree := New(sampleJson, sampleNodes)
+		if tt.toggle {
+			tree.ToggleLine(2)
+		}
+		reformatted := New(sorted, sortedNodes)
+		if ln := reformatted.SetFolding(tree.Folding(tt.cursor)); ln != tt.line || reformatted.Len() != tt.lines {
+			t.Errorf("SetFolding(cursor %v): line %v, %v lines, want %v, %v", tt.cursor, ln, reformatted.Len(), tt.line, tt.lines)
+		}
+	}
+
+	// The folding is carried over to a copy of the document as well, e.g.
+	// a more complete one shown while loading it.
+	tree := New(sampleJson, sampleNodes)
+	tree.ToggleLine(2)
+	c := jsonast.Partial(root)
+	copied := New(sampleJson, []*jsonast.Node{c, c.Children[0], c.Children[1], c.Children[1].Children[0], c.Children[1], c})
+	if ln := copied.SetFolding(tree.Folding(3)); ln != 3 || copied.Len() != 6 {
+		t.Errorf("SetFolding of copy: line %v, %v lines, want 3, 6", ln, copied.Len())
+	}
+}
+
+// createNodes parses the given JSON and returns the node for each of the
+// given child index paths, e.g. "1.0" for the first child of the second
+// child of the root.
+func createNodes(s string, paths ...string) []*jsonast.Node {
+	root, err := jsonast.Parse([]byte(s))
+	if err != nil {
+		panic(err)
+	}
+
+	var nodes []*jsonast.Node
+	for _, path := range paths {
+		n := root
+		for _, i := range strings.Split(path, ".") {
+			if i != "" {
+				idx, _ := strconv.Atoi(i)
+				n = n.Children[idx]
+			}
+		}
+		nodes = append(nodes, n)
+	}
+
+	return nodes
+}
+
 func createLinesFromString(s string) []Line {
 	var lines []Line
 	for _, ln := range strings.Split(s, "\n") {
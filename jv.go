@@ -1,12 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/maxzender/jv/colorwriter"
+	"github.com/maxzender/jv/jsonast"
 	"github.com/maxzender/jv/jsonfmt"
 	"github.com/maxzender/jv/jsontree"
 	"github.com/maxzender/jv/terminal"
@@ -15,12 +20,18 @@ import (
 
 var (
 	colorMap = map[jsonfmt.TokenType]termbox.Attribute{
-		jsonfmt.DelimiterType: termbox.ColorDefault,
-		jsonfmt.BoolType:      termbox.ColorRed,
-		jsonfmt.StringType:    termbox.ColorGreen,
-		jsonfmt.NumberType:    termbox.ColorYellow,
-		jsonfmt.NullType:      termbox.ColorMagenta,
-		jsonfmt.KeyType:       termbox.ColorBlue,
+		jsonfmt.DelimiterType:    termbox.ColorDefault,
+		jsonfmt.BoolType:         termbox.ColorRed,
+		jsonfmt.StringType:       termbox.ColorGreen,
+		jsonfmt.NumberType:       termbox.ColorYellow,
+		jsonfmt.NullType:         termbox.ColorMagenta,
+		jsonfmt.KeyType:          termbox.ColorBlue,
+		jsonfmt.LabelType:        termbox.ColorCyan,
+		jsonfmt.ErrorType:        termbox.ColorRed | termbox.AttrReverse,
+		jsonfmt.CommentType:      termbox.ColorBlack | termbox.AttrBold,
+		jsonfmt.DateTimeType:     termbox.ColorCyan | termbox.AttrBold,
+		jsonfmt.BytesType:        termbox.ColorCyan | termbox.AttrUnderline,
+		jsonfmt.DuplicateKeyType: termbox.ColorYellow | termbox.AttrReverse,
 	}
 )
 
@@ -30,9 +41,35 @@ func usage() {
 }
 
 func main() {
-	var showHelp bool
+	var showHelp, lines, lenient, printOutput bool
+	var colorMode string
+	var in input
+	var opts options
 	flag.BoolVar(&showHelp, "h", false, "print usage")
 	flag.BoolVar(&showHelp, "help", false, "print usage")
+	flag.BoolVar(&opts.sortKeys, "s", false, "sort object keys")
+	flag.BoolVar(&opts.sortKeys, "sort", false, "sort object keys")
+	flag.BoolVar(&opts.normalizeNumbers, "n", false, "show numbers in normalized notation")
+	flag.BoolVar(&opts.normalizeNumbers, "normalize", false, "show numbers in normalized notation")
+	flag.BoolVar(&opts.unescapeStrings, "u", false, "show strings decoded instead of escaped")
+	flag.BoolVar(&opts.unescapeStrings, "unescape", false, "show strings decoded instead of escaped")
+	flag.IntVar(&opts.indent, "indent", jsonfmt.IndentationDepth, "number of spaces to indent nested values by")
+	flag.BoolVar(&opts.tabs, "tabs", false, "indent nested values with tabs")
+	flag.BoolVar(&opts.compact, "compact", false, "write arrays and objects of scalars on a single line if they fit the width")
+	flag.BoolVar(&opts.alignColons, "align", false, "align the colons of the members of an object")
+	flag.IntVar(&opts.depth, "depth", 0, "expand values up to the given nesting level initially (default: only the top-level value)")
+	flag.BoolVar(&lines, "l", false, "read JSON Lines or concatenated JSON values")
+	flag.BoolVar(&lines, "lines", false, "read JSON Lines or concatenated JSON values")
+	flag.BoolVar(&lenient, "lenient", false, "read JSONC or JSON5, allowing comments, trailing commas etc.")
+	flag.StringVar(&in.format, "format", "", "input format: json, lines, json5, yaml, toml, csv, tsv, msgpack or cbor (default: from the file extension)")
+	flag.BoolVar(&in.inferTypes, "infer", false, "read numbers, booleans and null in CSV/TSV input instead of strings")
+	flag.BoolVar(&in.rawOnError, "raw-on-error", false, "show the raw input with the error highlighted if it cannot be parsed")
+	flag.BoolVar(&in.recover, "recover", false, "show as much as possible of malformed JSON and list the problems")
+	flag.BoolVar(&in.follow, "f", false, "follow a growing JSON Lines file, showing records as they are appended")
+	flag.BoolVar(&in.follow, "follow", false, "follow a growing JSON Lines file, showing records as they are appended")
+	flag.BoolVar(&printOutput, "p", false, "print the formatted input instead of viewing it (default if stdout is no terminal)")
+	flag.BoolVar(&printOutput, "print", false, "print the formatted input instead of viewing it (default if stdout is no terminal)")
+	flag.StringVar(&colorMode, "color", "auto", "color printed output: auto, always or never (auto honours NO_COLOR)")
 
 	flag.Usage = usage
 	flag.Parse()
@@ -41,54 +78,863 @@ func main() {
 		os.Exit(0)
 	}
 
-	reader := os.Stdin
-	var err error
-	if len(os.Args) > 1 {
-		reader, err = os.Open(os.Args[1])
+	if in.follow && flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "-f needs a file to follow")
+		os.Exit(2)
+	}
+	if opts.indent < 1 {
+		fmt.Fprintln(os.Stderr, "-indent must be at least 1")
+		os.Exit(2)
+	}
+	color, err := useColor(colorMode, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	switch {
+	case lines, in.follow:
+		in.format = "lines"
+	case lenient:
+		in.format = "json5"
+	case in.format == "" && flag.NArg() > 0:
+		in.format = formatFromFilename(flag.Arg(0))
+	}
+
+	reader, size := os.Stdin, int64(-1)
+	if flag.NArg() > 0 {
+		file, err := os.Open(flag.Arg(0))
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
 			os.Exit(1)
 		}
-		defer reader.Close()
+
+		if info, err := file.Stat(); err == nil && info.Mode().IsRegular() {
+			size = info.Size()
+		}
+		reader = file
 	}
 
-	content, err := ioutil.ReadAll(reader)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", err)
-		os.Exit(1)
+	var status int
+	if printOutput || !isTerminal(os.Stdout) {
+		status = printDocument(reader, os.Stdout, in, opts, color)
+	} else {
+		status = run(reader, size, in, opts)
 	}
+	// os.Exit skips deferred calls, so the file is closed here.
+	if reader != os.Stdin {
+		reader.Close()
+	}
+	os.Exit(status)
+}
 
-	os.Exit(run(content))
+// input describes how the content is read.
+type input struct {
+	format     string
+	inferTypes bool
+	rawOnError bool
+	recover    bool
+	follow     bool
 }
 
-func run(content []byte) int {
+// options holds the formatting choices that can be changed while viewing.
+type options struct {
+	sortKeys         bool
+	normalizeNumbers bool
+	unescapeStrings  bool
+	bytesBase64      bool
+
+	// indent is the number of spaces per nesting level unless tabs is set.
+	// compact writes arrays and objects of scalars on a single line if
+	// they fit into width columns.
+	indent      int
+	tabs        bool
+	compact     bool
+	alignColons bool
+	width       int
+
+	// depth is the nesting level up to which values are expanded after
+	// formatting, if set.
+	depth int
+
+	// plain leaves out record labels and errors, for printed output.
+	plain bool
+}
+
+// indents are the indentations switched between at runtime, in spaces.
+var indents = []int{2, 4, 8}
+
+// nextIndent switches to the next wider indentation, and from the widest
+// one to tabs and back to the narrowest.
+func (o *options) nextIndent() {
+	if o.tabs {
+		o.tabs, o.indent = false, indents[0]
+		return
+	}
+	for _, indent := range indents {
+		if indent > o.indent {
+			o.indent = indent
+			return
+		}
+	}
+	o.tabs = true
+}
+
+type viewer struct {
+	root *jsonast.Node
+	opts options
+	term *terminal.Terminal
+
+	// name is the name of the file shown, empty for stdin.
+	name string
+
+	// loading is the progress of loading the input, nil once it is done.
+	// err is the error shown with the raw input.
+	loading *loadProgress
+	err     error
+
+	// extend adds the records appended to a stream to the tree shown.
+	extend func()
+
+	// yanking is set after y was pressed, until the key choosing what to
+	// copy. notice reports the result until the next key is pressed.
+	yanking bool
+	notice  string
+
+	search search
+
+	// problems holds the nodes recovered from malformed input, problem
+	// the index of the one last jumped to.
+	problems []*jsonast.Node
+	problem  int
+
+	// duplicates holds the objects with duplicate keys, duplicate the
+	// index of the one last jumped to.
+	duplicates []*jsonast.Node
+	duplicate  int
+}
+
+// format lays out the document once to find the node of every line and
+// renders the lines only when they are shown. Indexed documents are laid out
+// from their index instead, see jsonast.Indexed. The returned function adds
+// records appended to an indexed stream root to the tree; it is nil for
+// other documents, which do not grow.
+func format(root *jsonast.Node, opts options) (*jsontree.JsonTree, func()) {
+	if root.Indexed() {
+		return formatIndexed(root, opts)
+	}
+
+	index := colorwriter.New(colorMap, termbox.ColorDefault)
+	index.SkipText = true
+	formatter := newFormatter(root, index, opts)
+	formatter.Format()
+
+	tree := jsontree.NewLazy(index.Nodes, func(from, to int) []jsontree.Line {
+		writer := colorwriter.New(colorMap, termbox.ColorDefault)
+		formatter.FormatLines(writer, from, to)
+		return writer.Lines
+	})
+	if opts.depth > 0 {
+		tree.ExpandToDepth(opts.depth, 0)
+	}
+	return tree, nil
+}
+
+// formatIndexed is format for indexed documents. Only the lines shown are
+// formatted, decoding the values on them.
+func formatIndexed(root *jsonast.Node, opts options) (*jsontree.JsonTree, func()) {
+	formatter := newFormatter(root, jsonfmt.Discard, opts)
+	doc := &indexedDocument{root: root, formatter: formatter, layout: formatter.Layout()}
+	tree := jsontree.NewDocument(doc)
+	if opts.depth > 0 {
+		tree.ExpandToDepth(opts.depth, 0)
+	}
+	extend := func() {
+		formatter.FormatAppended()
+		tree.Grow()
+	}
+	return tree, extend
+}
+
+// indexedDocument is the jsontree.Document of an indexed root, whose lines
+// its layout locates.
+type indexedDocument struct {
+	root      *jsonast.Node
+	formatter *jsonfmt.Formatter
+	layout    *jsonast.Layout
+}
+
+func (d *indexedDocument) Len() int {
+	return d.layout.Lines()
+}
+
+func (d *indexedDocument) Render(from, to int) []jsontree.Line {
 	writer := colorwriter.New(colorMap, termbox.ColorDefault)
-	formatter := jsonfmt.New(content, writer)
-	if err := formatter.Format(); err != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", err)
-		return 1
+	d.formatter.FormatLines(writer, from, to)
+	return writer.Lines
+}
+
+func (d *indexedDocument) Node(ln int) *jsonast.Node {
+	return d.layout.NodeAt(ln)
+}
+
+func (d *indexedDocument) Line(n *jsonast.Node, last bool) (int, bool) {
+	return d.layout.LineOf(n, last)
+}
+
+func (d *indexedDocument) Segments(from, to int, fn func(jsontree.Segment) bool) {
+	d.layout.Segments(from, to, func(start, end, depth int) bool {
+		return fn(jsontree.Segment{Start: start, End: end, Depth: depth})
+	})
+}
+
+func (d *indexedDocument) Children(ln int) (int, bool) {
+	if ln < 0 && d.root.Type != jsonast.Stream {
+		return 0, false
 	}
-	formattedJson := writer.Lines
+	return d.layout.Children(ln)
+}
+
+func (d *indexedDocument) Child(ln, i int) int {
+	return d.layout.ChildLine(ln, i)
+}
 
-	tree := jsontree.New(formattedJson)
-	term, err := terminal.New(tree)
+func (d *indexedDocument) ChildAt(ln, actualLn int) int {
+	return d.layout.ChildAt(ln, actualLn)
+}
+
+// newFormatter creates a formatter writing root to w with opts.
+func newFormatter(root *jsonast.Node, w jsonfmt.FormatWriter, opts options) *jsonfmt.Formatter {
+	formatOpts := jsonfmt.Options{
+		SortKeys:         opts.sortKeys,
+		NormalizeNumbers: opts.normalizeNumbers,
+		UnescapeStrings:  opts.unescapeStrings,
+		BytesBase64:      opts.bytesBase64,
+		Indent:           opts.indent,
+		Tabs:             opts.tabs,
+		AlignColons:      opts.alignColons,
+		Plain:            opts.plain,
+	}
+	if opts.compact {
+		formatOpts.InlineWidth = opts.width
+	}
+	return jsonfmt.New(root, w, formatOpts)
+}
+
+// extensionFormats maps file extensions to the input format they imply.
+var extensionFormats = map[string]string{
+	".jsonl":   "lines",
+	".ndjson":  "lines",
+	".json5":   "json5",
+	".jsonc":   "json5",
+	".yaml":    "yaml",
+	".yml":     "yaml",
+	".toml":    "toml",
+	".csv":     "csv",
+	".tsv":     "tsv",
+	".msgpack": "msgpack",
+	".mpk":     "msgpack",
+	".cbor":    "cbor",
+}
+
+func formatFromFilename(name string) string {
+	return extensionFormats[strings.ToLower(filepath.Ext(name))]
+}
+
+// parse reads content in the input format. Without a format, binary content
+// is read as MessagePack or CBOR, and content that is no valid JSON is tried
+// as JSONC/JSON5 and then as a stream of values. The progress of parsing
+// JSON is reported to progress, which may be nil.
+func parse(content []byte, in input, progress jsonast.Progress) (*jsonast.Node, error) {
+	switch in.format {
+	case "":
+	case "json":
+		return jsonast.IndexJSON(content, progress)
+	case "lines":
+		return jsonast.IndexStream(content, progress)
+	case "json5", "jsonc":
+		return jsonast.ParseProgress(content, true, progress)
+	case "yaml", "yml":
+		return jsonast.ParseYAML(content)
+	case "toml":
+		return jsonast.ParseTOML(content)
+	case "csv":
+		return jsonast.ParseCSV(content, ',', in.inferTypes)
+	case "tsv":
+		return jsonast.ParseCSV(content, '\t', in.inferTypes)
+	case "msgpack":
+		return jsonast.ParseMsgPack(content)
+	case "cbor":
+		return jsonast.ParseCBOR(content)
+	default:
+		return nil, fmt.Errorf("unknown input format %q", in.format)
+	}
+
+	if jsonast.IsCBOR(content) {
+		return jsonast.ParseCBOR(content)
+	}
+
+	root, err := jsonast.IndexJSON(content, progress)
+	if err == nil || err == jsonast.ErrCanceled {
+		return root, err
+	}
+
+	root, lenientErr := jsonast.ParseProgress(content, true, progress)
+	if lenientErr == nil || lenientErr == jsonast.ErrCanceled {
+		return root, lenientErr
+	}
+
+	stream, streamErr := jsonast.IndexStream(content, progress)
+	if streamErr != nil {
+		return nil, streamErr
+	}
+	if stream.Len() > 1 && stream.Child(0).Type != jsonast.Invalid {
+		return stream, nil
+	}
+
+	if root, ok := parseBinary(content); ok {
+		return root, nil
+	}
+
+	// Report whichever error was found further into the input, which is
+	// more likely to point at the actual problem.
+	if lenientErr.(*jsonast.SyntaxError).Pos.Offset > err.(*jsonast.SyntaxError).Pos.Offset {
+		err = lenientErr
+	}
+	return nil, err
+}
+
+// parseBinary decodes content that is no text as MessagePack or CBOR. Any
+// byte is a valid MessagePack integer, so the input is only taken for
+// either if it consists of maps and arrays, which start with a byte that
+// is no ASCII character, and decodes without leftovers.
+func parseBinary(content []byte) (*jsonast.Node, bool) {
+	if len(content) == 0 || content[0] < 0x80 {
+		return nil, false
+	}
+
+	for _, decode := range []func([]byte) (*jsonast.Node, error){jsonast.ParseMsgPack, jsonast.ParseCBOR} {
+		root, err := decode(content)
+		if err != nil {
+			continue
+		}
+
+		values := []*jsonast.Node{root}
+		if root.Type == jsonast.Stream {
+			values = root.Children
+		}
+		containers := true
+		for _, n := range values {
+			containers = containers && n.IsContainer()
+		}
+		if containers {
+			return root, true
+		}
+	}
+	return nil, false
+}
+
+// isBinary reports whether content cannot be text, because it is no valid
+// UTF-8 or contains control characters other than whitespace.
+func isBinary(content []byte) bool {
+	if !utf8.Valid(content) {
+		return true
+	}
+	for _, c := range content {
+		if c < 0x20 && c != '\t' && c != '\n' && c != '\r' {
+			return true
+		}
+	}
+	return false
+}
+
+// canRecover reports whether content in the given format can be read with
+// jsonast.ParseRecover.
+func canRecover(content []byte, format string) bool {
+	switch format {
+	case "json", "json5", "jsonc":
+		return true
+	case "":
+		return !isBinary(content)
+	}
+	return false
+}
+
+// run shows the input read from file, which is size bytes long or of
+// unknown size if size is negative. The viewer starts right away and shows
+// the progress of loading the input.
+func run(file *os.File, size int64, in input, opts options) (status int) {
+	term, err := terminal.New(emptyTree())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		return 1
 	}
-	defer term.Close()
 
+	// The lines shown are read from the file, which may be mapped.
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if err := truncated(file, size, recover()); err != nil {
+			term.Close()
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			status = 1
+		}
+	}()
+
+	opts.width = term.Width
+	l := newLoader(in, opts, size)
+	go l.load(file)
+
+	v := &viewer{opts: opts, term: term, loading: &loadProgress{phase: "reading", total: size}, problem: -1, duplicate: -1}
+	if file != os.Stdin {
+		v.name = file.Name()
+	}
+	// The loader's channels are set to nil when it is done, so a late
+	// progress report is not received any more. lines receives the lines
+	// appended to a followed file. ready is closed, so it can always be
+	// received from.
+	events, progress, done := term.Events(), l.progress, l.done
+	var lines chan []byte
+	ready := make(chan struct{})
+	close(ready)
 	for {
+		term.Status, term.StatusRight = v.status(), v.position()
+		term.EnsureCursorWithinWindow()
 		term.Render()
-		e := term.Poll()
-		if e.Ch == 'q' || e.Key == termbox.KeyCtrlC {
-			return 0
+
+		// While the matches of a search are looked for, the loop goes
+		// on after handling any event, see searchStep.
+		var searching chan struct{}
+		if v.searching() {
+			searching = ready
+		}
+		select {
+		case e := <-events:
+			if e.Type == termbox.EventResize {
+				term.Resize(e.Width, e.Height)
+				if v.opts.compact && v.opts.width != e.Width {
+					v.opts.width = e.Width
+					v.reformat()
+				}
+				continue
+			}
+			if e.Ch == 'q' && !v.yanking && !v.search.typing || e.Key == termbox.KeyCtrlC {
+				l.cancel()
+				term.Close()
+				if v.err != nil {
+					reportError(v.err, nil)
+				}
+				return 0
+			}
+			v.handleKeypress(e)
+		case p := <-progress:
+			v.loading = &p
+			if p.tree != nil && p.tree != term.Tree {
+				// The more complete document keeps the folding
+				// and the value the cursor is on.
+				folding := term.Tree.Folding(term.OffsetY + term.CursorY)
+				term.SetTreeAt(p.tree, p.tree.SetFolding(folding))
+				v.restartSearch(0)
+			}
+		case result := <-done:
+			progress, done = nil, nil
+			v.loading = nil
+			if !v.open(result, in) {
+				term.Close()
+				reportError(result.err, result.content)
+				return 1
+			}
+			if in.follow {
+				lines = follow(file, int64(len(result.content))).lines
+			}
+		case appended := <-lines:
+			v.appendRecords(appended)
+		case <-searching:
+			v.findMatches()
 		}
-		handleKeypress(term, tree, e)
 	}
 }
 
-func handleKeypress(t *terminal.Terminal, j *jsontree.JsonTree, e termbox.Event) {
+// open shows the loaded document. If it cannot be parsed, it is recovered
+// or shown raw if enabled; otherwise open returns false.
+func (v *viewer) open(result loadResult, in input) bool {
+	root, err := result.root, result.err
+	v.duplicates = result.duplicates
+	if _, ok := err.(*jsonast.SyntaxError); ok && in.recover && canRecover(result.content, in.format) {
+		root, v.problems = jsonast.ParseRecover(result.content)
+		v.duplicates = jsonast.FindDuplicates(root)
+		err = nil
+	}
+	if err == nil {
+		v.root = root
+		v.reformat()
+		return true
+	}
+
+	syntaxErr, ok := err.(*jsonast.SyntaxError)
+	if !ok || !in.rawOnError || syntaxErr.Pos.Line == 0 {
+		return false
+	}
+
+	tree, x := rawTree(result.content, syntaxErr.Pos)
+	v.err = err
+	v.term.SetTree(tree)
+	v.term.Status, v.term.StatusRight = v.status(), v.position()
+	v.term.MoveTo(x, syntaxErr.Pos.Line-1)
+	return true
+}
+
+// reportError prints err, with an excerpt of content around the position
+// of a syntax error.
+func reportError(err error, content []byte) {
+	fmt.Fprintf(os.Stderr, "parse error: %v\n", err)
+	if syntaxErr, ok := err.(*jsonast.SyntaxError); ok && content != nil {
+		fmt.Fprint(os.Stderr, jsonast.Excerpt(content, syntaxErr.Pos))
+	}
+}
+
+// emptyTree returns a tree with a single empty line, which is shown until
+// there is something to show.
+func emptyTree() *jsontree.JsonTree {
+	return jsontree.New([]jsontree.Line{nil}, []*jsonast.Node{nil})
+}
+
+// rawTree shows content as plain text, highlighting the character at pos,
+// for input that could not be parsed. It also returns the screen column of
+// the highlighted character.
+func rawTree(content []byte, pos jsonast.Position) (*jsontree.JsonTree, int) {
+	doc := newRawDocument(content, pos)
+	x := 0
+	if line := pos.Line - 1; line < doc.lines {
+		_, x = doc.render(line, doc.text(doc.offset(line)))
+	}
+	return jsontree.NewDocument(doc), x
+}
+
+// rawBlockSize is the number of lines of raw input between the ones whose
+// offsets are kept.
+const rawBlockSize = 64
+
+// rawDocument is the jsontree.Document of raw input. Its lines are found
+// from the offsets of every rawBlockSize-th line and only rendered when
+// they are shown.
+type rawDocument struct {
+	content []byte
+	pos     jsonast.Position
+	starts  []int
+	lines   int
+}
+
+func newRawDocument(content []byte, pos jsonast.Position) *rawDocument {
+	d := &rawDocument{content: content, pos: pos, starts: []int{0}, lines: 1}
+	for offset := 0; ; d.lines++ {
+		nl := bytes.IndexByte(content[offset:], '\n')
+		if nl < 0 {
+			break
+		}
+		offset += nl + 1
+		if d.lines%rawBlockSize == 0 {
+			d.starts = append(d.starts, offset)
+		}
+	}
+	return d
+}
+
+// offset returns the offset of the given line.
+func (d *rawDocument) offset(line int) int {
+	offset := d.starts[line/rawBlockSize]
+	for i := line / rawBlockSize * rawBlockSize; i < line; i++ {
+		offset += len(d.text(offset)) + 1
+	}
+	return offset
+}
+
+// text returns the line starting at offset, without its newline.
+func (d *rawDocument) text(offset int) []byte {
+	if nl := bytes.IndexByte(d.content[offset:], '\n'); nl >= 0 {
+		return d.content[offset : offset+nl]
+	}
+	return d.content[offset:]
+}
+
+// render renders the given line, which holds text, and returns the screen
+// column of the character highlighted on it.
+func (d *rawDocument) render(line int, text []byte) (jsontree.Line, int) {
+	writer := colorwriter.New(colorMap, termbox.ColorDefault)
+	s := strings.TrimRight(string(text), "\r")
+	if line != d.pos.Line-1 {
+		writer.Write(expandTabs(s), jsonfmt.WhiteSpaceType)
+		return writer.Lines[0], 0
+	}
+
+	runes := []rune(s)
+	col := min(len(runes), d.pos.Column-1)
+	before := expandTabs(string(runes[:col]))
+	writer.Write(before, jsonfmt.WhiteSpaceType)
+	if col < len(runes) {
+		writer.Write(expandTabs(string(runes[col])), jsonfmt.ErrorType)
+		writer.Write(expandTabs(string(runes[col+1:])), jsonfmt.WhiteSpaceType)
+	} else {
+		writer.Write(" ", jsonfmt.ErrorType)
+	}
+	return writer.Lines[0], len([]rune(before))
+}
+
+func (d *rawDocument) Len() int {
+	return d.lines
+}
+
+func (d *rawDocument) Render(from, to int) []jsontree.Line {
+	var lines []jsontree.Line
+	for line, offset := from, d.offset(from); line < to; line++ {
+		text := d.text(offset)
+		rendered, _ := d.render(line, text)
+		lines = append(lines, rendered)
+		offset += len(text) + 1
+	}
+	return lines
+}
+
+// The raw input has no nodes, so nothing can be folded.
+func (d *rawDocument) Node(int) *jsonast.Node                         { return nil }
+func (d *rawDocument) Line(*jsonast.Node, bool) (int, bool)           { return 0, false }
+func (d *rawDocument) Segments(int, int, func(jsontree.Segment) bool) {}
+func (d *rawDocument) Children(int) (int, bool)                       { return 0, false }
+func (d *rawDocument) Child(int, int) int                             { return 0 }
+func (d *rawDocument) ChildAt(int, int) int                           { return 0 }
+
+func expandTabs(s string) string {
+	return strings.Replace(s, "\t", "    ", -1)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// status shows the query being typed, what can be copied after y, or the
+// result of the last command. Next come the progress of loading the input
+// or its parse error. Otherwise it shows the path of the node under the
+// cursor and the last search, followed by the problems of recovered input
+// and the objects with duplicate keys, or a description of the one under
+// the cursor.
+func (v *viewer) status() string {
+	if v.search.typing {
+		return v.searchStatus()
+	}
+	if v.yanking {
+		return yankMenu
+	}
+	if v.notice != "" {
+		return v.notice
+	}
+	if v.loading != nil {
+		return v.loading.String()
+	}
+	if v.err != nil {
+		return fmt.Sprintf("parse error: %v", v.err)
+	}
+
+	current := v.term.CurrentNode()
+	problems, atProblem := v.problemStatus(current)
+	duplicates, atDuplicate := v.duplicateStatus(current)
+	message := problems
+	if atDuplicate && !atProblem || problems == "" {
+		message = duplicates
+	}
+
+	var parts []string
+	if current != nil {
+		parts = append(parts, current.Location())
+	}
+	for _, part := range []string{v.searchStatus(), message} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "  ")
+}
+
+// position shows the name of the file and the line of the cursor, also as
+// a percentage of the lines shown.
+func (v *viewer) position() string {
+	t := v.term
+	position := t.Tree.Position(t.OffsetY + t.CursorY)
+	if v.name == "" {
+		return position
+	}
+	return v.name + "  " + position
+}
+
+// problemStatus lists the problems of recovered input, or describes the
+// one at current and reports that it did.
+func (v *viewer) problemStatus(current *jsonast.Node) (string, bool) {
+	if len(v.problems) == 0 {
+		return "", false
+	}
+
+	errs := make([]string, len(v.problems))
+	for i, n := range v.problems {
+		if n == current {
+			return fmt.Sprintf("problem %d/%d: %v", i+1, len(v.problems), n.Err), true
+		}
+		errs[i] = fmt.Sprint(n.Err)
+	}
+
+	if len(v.problems) == 1 {
+		return fmt.Sprintf("1 problem (e: go to it): %s", errs[0]), false
+	}
+	return fmt.Sprintf("%d problems (e: go to the next): %s", len(v.problems), strings.Join(errs, "; ")), false
+}
+
+// duplicateStatus lists the objects with duplicate keys, or describes the
+// one at current, or containing current as a duplicate member, and reports
+// that it did.
+func (v *viewer) duplicateStatus(current *jsonast.Node) (string, bool) {
+	if len(v.duplicates) == 0 {
+		return "", false
+	}
+
+	paths := make([]string, len(v.duplicates))
+	for i, n := range v.duplicates {
+		if n.Same(current) || current != nil && current.Duplicate && current.Parent.Same(n) {
+			return fmt.Sprintf("duplicate keys %d/%d in %s: %s", i+1, len(v.duplicates), n.Location(), duplicateKeys(n)), true
+		}
+		paths[i] = n.Location()
+	}
+
+	if len(v.duplicates) == 1 {
+		return fmt.Sprintf("1 object with duplicate keys (d: go to it): %s", paths[0]), false
+	}
+	return fmt.Sprintf("%d objects with duplicate keys (d: go to the next): %s", len(v.duplicates), strings.Join(paths, ", ")), false
+}
+
+// duplicateKeys lists the keys used more than once in obj and how often,
+// in the order they first appear.
+func duplicateKeys(obj *jsonast.Node) string {
+	counts := map[string]int{}
+	var keys []string
+	obj.Range(0, func(member *jsonast.Node) bool {
+		if member.Duplicate {
+			if counts[member.Key] == 0 {
+				keys = append(keys, member.Key)
+			}
+			counts[member.Key]++
+		}
+		return true
+	})
+
+	described := make([]string, len(keys))
+	for i, key := range keys {
+		described[i] = fmt.Sprintf("%s (%d×)", jsonast.Quote(key), counts[key])
+	}
+	return strings.Join(described, ", ")
+}
+
+// nextProblem moves the cursor to the next problem, expanding the
+// containers around it. The closing bracket of an automatically closed
+// container is where its problem is shown.
+func (v *viewer) nextProblem() {
+	if len(v.problems) == 0 {
+		return
+	}
+
+	v.problem = (v.problem + 1) % len(v.problems)
+	n := v.problems[v.problem]
+	if ln, ok := v.term.Tree.Reveal(n, n.IsContainer()); ok {
+		v.term.MoveTo(0, ln)
+	}
+}
+
+// nextDuplicate moves the cursor to the first duplicate key of the next
+// object with duplicate keys, expanding the containers around it.
+func (v *viewer) nextDuplicate() {
+	if len(v.duplicates) == 0 {
+		return
+	}
+
+	v.duplicate = (v.duplicate + 1) % len(v.duplicates)
+	v.duplicates[v.duplicate].Range(0, func(member *jsonast.Node) bool {
+		if !member.Duplicate {
+			return true
+		}
+		if ln, ok := v.term.Tree.Reveal(member, false); ok {
+			v.term.MoveTo(0, ln)
+		}
+		return false
+	})
+}
+
+// reformat renders the document again with the current options. It does
+// nothing if the raw input is shown.
+func (v *viewer) reformat() {
+	if v.root == nil {
+		return
+	}
+
+	// Reformatting the document shown, or the part of it shown while
+	// loading, keeps its folding and the value the cursor is on, which are
+	// mapped to the new lines.
+	t := v.term
+	folding := t.Tree.Folding(t.OffsetY + t.CursorY)
+	var tree *jsontree.JsonTree
+	tree, v.extend = format(v.root, v.opts)
+	t.SetTreeAt(tree, tree.SetFolding(folding))
+	v.restartSearch(0)
+}
+
+// fold applies a change of the expanded segments, keeping the cursor on
+// its line or the collapsed segment now hiding it. change is passed the
+// line of the cursor and returns where it went.
+func (v *viewer) fold(change func(virtualLn int) int) {
+	t := v.term
+	t.MoveTo(t.OffsetX+t.CursorX, change(t.OffsetY+t.CursorY))
+}
+
+// appendRecords adds the records on lines read from a followed file to the
+// stream shown. If the cursor is on the last line, it moves on to the new
+// one.
+func (v *viewer) appendRecords(lines []byte) {
+	if v.root == nil || v.root.Type != jsonast.Stream || v.extend == nil {
+		return
+	}
+
+	// Only the new lines are searched, and the last one, which may have
+	// changed.
+	t := v.term
+	atEnd := t.Tree.Line(t.OffsetY+t.CursorY+1) == nil
+	v.restartSearch(max(0, t.Tree.Lines()-1))
+	for _, n := range jsonast.Append(v.root, lines) {
+		v.duplicates = append(v.duplicates, jsonast.FindDuplicates(n)...)
+	}
+	v.extend()
+
+	if atEnd {
+		t.MoveToLast()
+	}
+}
+
+func (v *viewer) handleKeypress(e termbox.Event) {
+	t, j := v.term, v.term.Tree
+	v.notice, v.search.waiting = "", false
+	if v.yanking {
+		v.yanking = false
+		v.yank(e.Ch)
+		return
+	}
+	if v.search.typing {
+		v.handleSearchKey(e)
+		return
+	}
+
 	if e.Ch == 0 {
 		switch e.Key {
 		case termbox.KeyArrowUp:
@@ -103,6 +949,8 @@ func handleKeypress(t *terminal.Terminal, j *jsontree.JsonTree, e termbox.Event)
 			j.ToggleLine(t.CursorY + t.OffsetY)
 		case termbox.KeySpace:
 			j.ToggleLine(t.CursorY + t.OffsetY)
+		case termbox.KeyEsc:
+			v.clearSearch()
 		}
 	} else {
 		switch e.Ch {
@@ -114,6 +962,56 @@ func handleKeypress(t *terminal.Terminal, j *jsontree.JsonTree, e termbox.Event)
 			t.MoveCursor(0, -1)
 		case 'l':
 			t.MoveCursor(+1, 0)
+		case 's':
+			v.opts.sortKeys = !v.opts.sortKeys
+			v.reformat()
+		case '#':
+			v.opts.normalizeNumbers = !v.opts.normalizeNumbers
+			v.reformat()
+		case 'u':
+			v.opts.unescapeStrings = !v.opts.unescapeStrings
+			v.reformat()
+		case 'b':
+			v.opts.bytesBase64 = !v.opts.bytesBase64
+			v.reformat()
+		case 'i':
+			v.opts.nextIndent()
+			v.reformat()
+		case 'c':
+			v.opts.compact = !v.opts.compact
+			v.reformat()
+		case 'a':
+			v.opts.alignColons = !v.opts.alignColons
+			v.reformat()
+		case 'e':
+			v.nextProblem()
+		case 'd':
+			v.nextDuplicate()
+		case 'E':
+			v.fold(j.ExpandAll)
+		case 'C':
+			v.fold(j.CollapseAll)
+		case '+':
+			v.fold(j.ExpandRecursively)
+		case '-':
+			v.fold(j.CollapseRecursively)
+		case 'p':
+			v.fold(j.CollapseParent)
+		case 'y':
+			v.yanking = true
+		case '/':
+			v.startSearch(false)
+		case '?':
+			v.startSearch(true)
+		case 'n':
+			v.nextMatch(v.search.backward)
+		case 'N':
+			v.nextMatch(!v.search.backward)
+		case '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			v.opts.depth = int(e.Ch - '0')
+			v.fold(func(virtualLn int) int {
+				return j.ExpandToDepth(v.opts.depth, virtualLn)
+			})
 		}
 	}
 }
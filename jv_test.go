@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/maxzender/jv/jsonast"
+)
+
+func TestParseDetect(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		typ     jsonast.NodeType
+		records int
+	}{
+		{"JSON", `{"a": [1, 2]}`, jsonast.Object, 0},
+		{"Latin-1 JSON", "{\"name\": \"Jos\xe9\", \"age\": 42}\n", jsonast.Object, 0},
+		{"JSON5", "{a: 'b', // comment\n}", jsonast.Object, 0},
+		{"JSON Lines", "{\"a\": 1}\n{\"b\": \xff}\n", jsonast.Stream, 2},
+		{"MessagePack", "\x82\xa1a\x01\xa1b\x92\x01\x02", jsonast.Object, 0},
+		{"MessagePack stream", "\x81\xa1a\x01\x91\x02", jsonast.Stream, 2},
+		{"CBOR", "\xa1\x61a\x9f\x01\xff", jsonast.Object, 0},
+	}
+
+	for _, tt := range tests {
+		root, err := parse([]byte(tt.content), input{}, func(int, *jsonast.Node) bool { return true })
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if root.Type != tt.typ || tt.typ == jsonast.Stream && root.Len() != tt.records {
+			t.Errorf("%s: %v with %d children, want %v", tt.name, root.Type, root.Len(), tt.typ)
+		}
+	}
+
+	// Any byte is a MessagePack integer, but text that fails to parse must
+	// be reported as such rather than shown as a sequence of numbers.
+	for _, content := range []string{"{\"name\": \"Jos\xe9\"", "caf\xe9\n", "\xe9t\xe9"} {
+		if root, err := parse([]byte(content), input{}, func(int, *jsonast.Node) bool { return true }); err == nil {
+			t.Errorf("parse(%q): %v, want error", content, root.Type)
+		}
+	}
+}
+
+func TestPrintStream(t *testing.T) {
+	content := "{\"a\": [1, {\"b\": null}]}\n\"x\"\n[]\n{\"c\": 2.50}\n"
+	file, err := ioutil.TempFile("", "jv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	file.WriteString(content)
+	file.Seek(0, io.SeekStart)
+
+	var out bytes.Buffer
+	if status := printDocument(file, &out, input{format: "lines"}, options{compact: true}, false); status != 0 {
+		t.Fatalf("printDocument: status %d", status)
+	}
+
+	// The output has to be a sequence of JSON values like the input, e.g.
+	// for jq.
+	decode := func(s string) []interface{} {
+		var values []interface{}
+		decoder := json.NewDecoder(bytes.NewBufferString(s))
+		for {
+			var v interface{}
+			if err := decoder.Decode(&v); err == io.EOF {
+				return values
+			} else if err != nil {
+				t.Fatalf("printed output:\n%s\n%v", out.String(), err)
+			}
+			values = append(values, v)
+		}
+	}
+	if actual, expected := decode(out.String()), decode(content); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("printed %v, want %v", actual, expected)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/maxzender/jv/jsonast"
+	"github.com/maxzender/jv/jsontree"
+)
+
+const (
+	// readChunkSize is the number of bytes read between progress reports.
+	readChunkSize = 1 << 20
+
+	// reportInterval is the minimum time between progress reports.
+	reportInterval = 100 * time.Millisecond
+
+	// progressBarWidth is the number of cells of the progress bar.
+	progressBarWidth = 20
+)
+
+// loader reads and parses the input in the background, so the viewer can
+// start right away. It reports its progress, including the top-level values
+// parsed so far, on progress and the result on done.
+type loader struct {
+	in   input
+	opts options
+	size int64
+
+	progress chan loadProgress
+	done     chan loadResult
+	canceled int32
+
+	reported  time.Time
+	top       *jsonast.Node
+	tree      *jsontree.JsonTree
+	published int
+}
+
+// loadProgress describes how far loading got. total is -1 if the size of
+// the input is unknown. tree shows the top-level values parsed so far, or
+// is nil if there are none yet.
+type loadProgress struct {
+	phase       string
+	done, total int64
+	tree        *jsontree.JsonTree
+}
+
+type loadResult struct {
+	content    []byte
+	root       *jsonast.Node
+	duplicates []*jsonast.Node
+	err        error
+}
+
+// newLoader creates a loader for size bytes of input, or an unknown amount
+// if size is negative. Partial documents are formatted with opts.
+func newLoader(in input, opts options, size int64) *loader {
+	return &loader{
+		in:       in,
+		opts:     opts,
+		size:     size,
+		progress: make(chan loadProgress, 1),
+		done:     make(chan loadResult, 1),
+	}
+}
+
+// load reads and parses file and sends the result on done unless loading
+// has been canceled.
+func (l *loader) load(file *os.File) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if err := truncated(file, l.size, recover()); err != nil {
+			l.done <- loadResult{err: err}
+		}
+	}()
+
+	content, err := l.read(file)
+	if l.in.follow {
+		content = completeLines(content)
+	}
+
+	var root *jsonast.Node
+	if err == nil {
+		l.report(loadProgress{phase: "parsing", total: -1}, true)
+		root, err = parse(content, l.in, l.parseProgress(len(content)))
+	}
+
+	// Looking for duplicate keys takes a while for huge documents, so it
+	// is done here rather than when the viewer shows the result.
+	var duplicates []*jsonast.Node
+	if err == nil {
+		duplicates = jsonast.FindDuplicates(root)
+	}
+
+	if err != jsonast.ErrCanceled {
+		l.done <- loadResult{content: content, root: root, duplicates: duplicates, err: err}
+	}
+}
+
+// cancel stops loading at the next progress report.
+func (l *loader) cancel() {
+	atomic.StoreInt32(&l.canceled, 1)
+}
+
+func (l *loader) isCanceled() bool {
+	return atomic.LoadInt32(&l.canceled) != 0
+}
+
+func (l *loader) read(file *os.File) ([]byte, error) {
+	if content, ok := mapInput(file, l.in); ok {
+		return content, nil
+	}
+
+	// Growing the buffer to the size up front saves copying it while
+	// reading, which matters for huge files.
+	var buf bytes.Buffer
+	if l.size > 0 {
+		buf.Grow(int(l.size) + bytes.MinRead)
+	}
+
+	for {
+		if l.isCanceled() {
+			return nil, jsonast.ErrCanceled
+		}
+		_, err := io.CopyN(&buf, file, readChunkSize)
+		if err == io.EOF {
+			return buf.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		l.report(loadProgress{phase: "reading", done: int64(buf.Len()), total: l.size}, false)
+	}
+}
+
+// mapInput maps file into memory if it is a regular file, see mapFile. A
+// followed file is read instead, as it may be truncated.
+func mapInput(file *os.File, in input) ([]byte, bool) {
+	if in.follow {
+		return nil, false
+	}
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() || info.Size() == 0 {
+		return nil, false
+	}
+	content, err := mapFile(file, info.Size())
+	return content, err == nil
+}
+
+// errTruncated is reported if a mapped file shrinks while it is read.
+var errTruncated = errors.New("the file was truncated while it was read")
+
+// truncated returns errTruncated for r, a value recovered from a panic, if
+// file is now smaller than size, the size it was mapped with: the panic is
+// then the fault of reading past its end, e.g. after a log was rotated.
+// Faults only panic rather than crash the program on goroutines that set
+// debug.SetPanicOnFault. Other panics are passed on.
+func truncated(file *os.File, size int64, r interface{}) error {
+	if r == nil {
+		return nil
+	}
+	if info, err := file.Stat(); err == nil && info.Size() < size {
+		return errTruncated
+	}
+	panic(r)
+}
+
+// parseProgress returns the Progress function for parsing size bytes. It
+// shows the top-level values parsed so far whenever their number has
+// doubled, so formatting them takes linear time overall.
+func (l *loader) parseProgress(size int) jsonast.Progress {
+	return func(offset int, top *jsonast.Node) bool {
+		if l.isCanceled() {
+			return false
+		}
+
+		if top != l.top {
+			// Parsing has started over, e.g. leniently after strict
+			// parsing failed, so the values shown so far are dropped.
+			l.top, l.published = top, 0
+			if l.tree != nil {
+				l.tree = emptyTree()
+			}
+		}
+
+		if top != nil && (top.IsContainer() || top.Type == jsonast.Stream) {
+			if complete := top.Len(); complete > 0 && complete >= 2*l.published {
+				l.tree, _ = format(jsonast.Partial(top), l.opts)
+				l.published = complete
+			}
+		}
+		l.report(loadProgress{phase: "parsing", done: int64(offset), total: int64(size)}, false)
+		return true
+	}
+}
+
+// report sends p with the latest partial document, replacing a report the
+// viewer has not received yet. Unless force is set, reports are sent at
+// most every reportInterval.
+func (l *loader) report(p loadProgress, force bool) {
+	if !force && time.Since(l.reported) < reportInterval {
+		return
+	}
+	l.reported = time.Now()
+
+	p.tree = l.tree
+	select {
+	case <-l.progress:
+	default:
+	}
+	l.progress <- p
+}
+
+// String describes the progress for the status line.
+func (p loadProgress) String() string {
+	if p.total <= 0 {
+		if p.done == 0 {
+			return fmt.Sprintf("%s… (q: cancel)", p.phase)
+		}
+		return fmt.Sprintf("%s… %s (q: cancel)", p.phase, formatSize(p.done))
+	}
+
+	done := p.done
+	if done > p.total {
+		done = p.total
+	}
+	filled := int(progressBarWidth * done / p.total)
+	return fmt.Sprintf("%s [%s%s] %d%% %s / %s (q: cancel)",
+		p.phase,
+		strings.Repeat("█", filled),
+		strings.Repeat("░", progressBarWidth-filled),
+		100*done/p.total,
+		formatSize(p.done),
+		formatSize(p.total))
+}
+
+// formatSize formats a number of bytes with a binary unit.
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
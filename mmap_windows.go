@@ -0,0 +1,11 @@
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// mapFile is not supported on Windows, files are read instead.
+func mapFile(*os.File, int64) ([]byte, error) {
+	return nil, errors.New("mapping files is not supported")
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime/debug"
+	"strconv"
+
+	"github.com/maxzender/jv/ansiwriter"
+	"github.com/maxzender/jv/jsonast"
+	"github.com/maxzender/jv/jsonfmt"
+	termbox "github.com/nsf/termbox-go"
+)
+
+// useColor decides whether printed output is colored: always, never, or
+// for auto only if out is a terminal and NO_COLOR is not set.
+func useColor(mode string, out *os.File) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		return os.Getenv("NO_COLOR") == "" && isTerminal(out), nil
+	}
+	return false, fmt.Errorf("invalid -color %q: use auto, always or never", mode)
+}
+
+// defaultWidth is the width printed output is fitted into if the terminal
+// width is unknown.
+const defaultWidth = 80
+
+// printWidth returns the width to fit printed output into, taken from the
+// COLUMNS environment variable if set.
+func printWidth() int {
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		return columns
+	}
+	return defaultWidth
+}
+
+// printDocument pretty-prints the input read from file to out instead of
+// viewing it, like a formatter in a pipeline. Record labels and errors are
+// left out, so the output can be read by other programs; the errors are
+// reported on stderr. A followed file is printed record by record as it
+// grows.
+func printDocument(file *os.File, out io.Writer, in input, opts options, color bool) (status int) {
+	content, ok := mapInput(file, in)
+	if ok {
+		size := int64(len(content))
+		defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+		defer func() {
+			if err := truncated(file, size, recover()); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				status = 1
+			}
+		}()
+	} else {
+		var err error
+		if content, err = ioutil.ReadAll(file); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+	if in.follow {
+		content = completeLines(content)
+	}
+
+	root, err := parse(content, in, nil)
+	var problems []*jsonast.Node
+	if _, ok := err.(*jsonast.SyntaxError); ok && in.recover && canRecover(content, in.format) {
+		root, problems = jsonast.ParseRecover(content)
+		err = nil
+	}
+	if err != nil {
+		reportError(err, content)
+		return 1
+	}
+	jsonast.FindDuplicates(root)
+
+	var colors map[jsonfmt.TokenType]termbox.Attribute
+	if color {
+		colors = colorMap
+	}
+	opts.width, opts.plain = printWidth(), true
+	writer := ansiwriter.New(out, colors)
+	formatter := newFormatter(root, writer, opts)
+	formatter.Format()
+	if err := writer.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	for _, n := range problems {
+		fmt.Fprintf(os.Stderr, "problem: %v\n", n.Err)
+	}
+	if root.Type == jsonast.Stream {
+		root.Range(0, func(record *jsonast.Node) bool {
+			reportInvalid(record)
+			return true
+		})
+	}
+
+	if !in.follow {
+		return 0
+	}
+	for lines := range follow(file, int64(len(content))).lines {
+		for _, n := range jsonast.Append(root, lines) {
+			jsonast.FindDuplicates(n)
+			reportInvalid(n)
+		}
+		formatter.FormatAppended()
+		if err := writer.Flush(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+// reportInvalid reports a record of a stream that could not be parsed on
+// stderr, as printed output leaves out its error.
+func reportInvalid(record *jsonast.Node) {
+	if record.Type == jsonast.Invalid {
+		fmt.Fprintf(os.Stderr, "record %d: %v\n", record.Record(), record.Err)
+	}
+}
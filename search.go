@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/maxzender/jv/jsontree"
+	termbox "github.com/nsf/termbox-go"
+)
+
+// search holds the state of searching the document with / and ?. While a
+// query is typed, its matches are highlighted; once it is entered, the
+// lines of the fully expanded tree it matches are kept in matches. The
+// tree is searched a part at a time, matches holds the ones on the lines
+// before scanned. waiting is set while the cursor waits for the next
+// match, or the previous one if waitBackward is set.
+type search struct {
+	typing   bool
+	query    string
+	backward bool
+
+	pattern string
+	re      *regexp.Regexp
+	matches []int
+	scanned int
+
+	waiting, waitBackward bool
+}
+
+// searchStep is how long the matches are looked for at a time between
+// handling events, so the viewer stays responsive while searching a huge
+// document.
+const searchStep = 50 * time.Millisecond
+
+// startSearch starts typing a query to search forward, or backward for ?.
+func (v *viewer) startSearch(backward bool) {
+	v.search.typing, v.search.query, v.search.backward = true, "", backward
+}
+
+// handleSearchKey edits the query being typed. Enter searches for it, or
+// for the previous query if it is empty, and Esc cancels typing.
+func (v *viewer) handleSearchKey(e termbox.Event) {
+	s := &v.search
+	switch {
+	case e.Key == termbox.KeyEnter:
+		s.typing = false
+		if s.query != "" {
+			s.pattern, s.re = s.query, compileQuery(s.query)
+			v.restartSearch(0)
+		}
+		v.term.Highlight = s.re
+		v.nextMatch(s.backward)
+		return
+	case e.Key == termbox.KeyEsc:
+		s.typing = false
+		v.term.Highlight = s.re
+		return
+	case e.Key == termbox.KeyBackspace || e.Key == termbox.KeyBackspace2:
+		_, size := utf8.DecodeLastRuneInString(s.query)
+		s.query = s.query[:len(s.query)-size]
+	case e.Key == termbox.KeySpace:
+		s.query += " "
+	case e.Ch != 0:
+		s.query += string(e.Ch)
+	default:
+		return
+	}
+
+	v.term.Highlight = compileQuery(s.query)
+}
+
+// clearSearch ends highlighting the matches of the last search.
+func (v *viewer) clearSearch() {
+	v.search = search{}
+	v.term.Highlight = nil
+}
+
+// compileQuery returns the expression matching query literally, ignoring
+// case unless query contains upper case letters, or nil if it is empty.
+func compileQuery(query string) *regexp.Regexp {
+	if query == "" {
+		return nil
+	}
+	for _, r := range query {
+		if unicode.IsUpper(r) {
+			return regexp.MustCompile(regexp.QuoteMeta(query))
+		}
+	}
+	return regexp.MustCompile("(?i)" + regexp.QuoteMeta(query))
+}
+
+// restartSearch looks for the matches from line ln of the tree shown on
+// again, e.g. after the lines from it on have changed.
+func (v *viewer) restartSearch(ln int) {
+	s := &v.search
+	s.matches = s.matches[:sort.SearchInts(s.matches, ln)]
+	s.scanned = min(s.scanned, ln)
+}
+
+// searching reports whether the tree shown has not been searched for all
+// matches yet.
+func (v *viewer) searching() bool {
+	return v.search.re != nil && v.search.scanned < v.term.Tree.Lines()
+}
+
+// findMatches looks for more matches of the search, for about searchStep.
+// If the cursor waits for the next match, it moves to it once found.
+func (v *viewer) findMatches() {
+	s, tree := &v.search, v.term.Tree
+	for start := time.Now(); v.searching() && time.Since(start) < searchStep; {
+		to := s.scanned + searchBlock
+		s.matches = append(s.matches, tree.Find(s.re, s.scanned, to)...)
+		s.scanned = min(to, tree.Lines())
+	}
+	if s.waiting {
+		v.nextMatch(s.waitBackward)
+	}
+}
+
+// searchBlock is the number of lines searched at once.
+const searchBlock = 1024
+
+// nextMatch moves the cursor to the next match after it, or before it if
+// backward is set, expanding the segments hiding the match. The search
+// wraps around at the end of the document. If the match has not been
+// found yet, the cursor waits for it, see findMatches.
+func (v *viewer) nextMatch(backward bool) {
+	s := &v.search
+	s.waiting = false
+	if s.re == nil {
+		return
+	}
+
+	t := v.term
+	current, _ := t.Tree.ActualLine(t.OffsetY + t.CursorY)
+	i := sort.SearchInts(s.matches, current+1)
+	found := i < len(s.matches)
+	if backward {
+		i = sort.SearchInts(s.matches, current) - 1
+		found = i >= 0 && s.scanned > current
+	}
+	if !found && v.searching() {
+		s.waiting, s.waitBackward = true, backward
+		return
+	}
+	if len(s.matches) == 0 {
+		v.notice = "pattern not found: " + s.pattern
+		return
+	}
+
+	switch {
+	case i == len(s.matches):
+		i = 0
+		v.notice = "search hit the end, continuing at the top"
+	case i < 0:
+		i = len(s.matches) - 1
+		v.notice = "search hit the top, continuing at the end"
+	}
+
+	ln := t.Tree.RevealLine(s.matches[i])
+	t.MoveTo(matchColumn(t.Tree.Line(ln), s.re), ln)
+}
+
+// matchColumn returns the column of the first match of re on line, or 0
+// if the match is not shown on it, e.g. in an unescaped string.
+func matchColumn(line jsontree.Line, re *regexp.Regexp) int {
+	text := line.String()
+	if loc := re.FindStringIndex(text); loc != nil {
+		return utf8.RuneCountInString(text[:loc[0]])
+	}
+	return 0
+}
+
+// searchStatus shows the query being typed, or the last search with the
+// number of the match under the cursor or of all matches, which is
+// followed by + while more may be found.
+func (v *viewer) searchStatus() string {
+	s := v.search
+	prefix := "/"
+	if s.backward {
+		prefix = "?"
+	}
+	if s.typing {
+		return prefix + s.query
+	}
+	if s.re == nil {
+		return ""
+	}
+
+	t := v.term
+	current, _ := t.Tree.ActualLine(t.OffsetY + t.CursorY)
+	count := fmt.Sprint(len(s.matches))
+	if v.searching() {
+		count += "+"
+	}
+	if i := sort.SearchInts(s.matches, current); i < len(s.matches) && s.matches[i] == current {
+		return fmt.Sprintf("%s%s %d/%s", prefix, s.pattern, i+1, count)
+	}
+	if count == "1" {
+		return fmt.Sprintf("%s%s 1 match", prefix, s.pattern)
+	}
+	return fmt.Sprintf("%s%s %s matches", prefix, s.pattern, count)
+}
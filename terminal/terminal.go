@@ -1,6 +1,10 @@
 package terminal
 
 import (
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/maxzender/jv/jsonast"
 	"github.com/maxzender/jv/jsontree"
 	"github.com/nsf/termbox-go"
 )
@@ -10,6 +14,14 @@ type Terminal struct {
 	CursorX, CursorY int
 	OffsetX, OffsetY int
 	Tree             *jsontree.JsonTree
+
+	// Status is shown in the last row, StatusRight at its right end. The
+	// row shows content instead if both are empty.
+	Status      string
+	StatusRight string
+
+	// Highlight marks its matches on the lines shown, e.g. for a search.
+	Highlight *regexp.Regexp
 }
 
 func New(tree *jsontree.JsonTree) (*Terminal, error) {
@@ -22,6 +34,11 @@ func New(tree *jsontree.JsonTree) (*Terminal, error) {
 	return &Terminal{Width: w, Height: h, Tree: tree}, nil
 }
 
+// CurrentNode returns the document node on the line under the cursor.
+func (t *Terminal) CurrentNode() *jsonast.Node {
+	return t.Tree.Node(t.OffsetY + t.CursorY)
+}
+
 func (t *Terminal) MoveCursor(x, y int) {
 	currentLine := t.Tree.Line(t.OffsetY + t.CursorY)
 	nextLine := t.Tree.Line(t.OffsetY + t.CursorY + y)
@@ -30,7 +47,7 @@ func (t *Terminal) MoveCursor(x, y int) {
 		t.OffsetX++
 	} else if t.CursorX+x < 0 && t.OffsetX > 0 {
 		t.OffsetX--
-	} else if t.CursorY+y == t.Height && nextLine != nil {
+	} else if t.CursorY+y == t.viewHeight() && nextLine != nil {
 		t.OffsetY++
 	} else if t.CursorY+y < 0 && t.OffsetY > 0 {
 		t.OffsetY--
@@ -40,6 +57,42 @@ func (t *Terminal) MoveCursor(x, y int) {
 	}
 }
 
+// MoveTo places the cursor on column x of the given line, scrolling so that
+// it is centered if it is not visible yet.
+func (t *Terminal) MoveTo(x, virtualLn int) {
+	if virtualLn < t.OffsetY || virtualLn >= t.OffsetY+t.viewHeight() {
+		t.OffsetY = max(0, virtualLn-t.viewHeight()/2)
+	}
+	if x < t.OffsetX || x >= t.OffsetX+t.Width {
+		t.OffsetX = max(0, x-t.Width/2)
+	}
+	t.CursorX, t.CursorY = x-t.OffsetX, virtualLn-t.OffsetY
+}
+
+// MoveToLast places the cursor on the last line, scrolled to the bottom of
+// the view.
+func (t *Terminal) MoveToLast() {
+	last := t.Tree.Len() - 1
+	t.OffsetY = max(0, last-t.viewHeight()+1)
+	t.CursorY = last - t.OffsetY
+}
+
+// SetTree replaces the displayed tree, e.g. after the content has been
+// formatted with different options.
+func (t *Terminal) SetTree(tree *jsontree.JsonTree) {
+	t.Tree = tree
+	t.OffsetX, t.OffsetY = 0, 0
+	t.EnsureCursorWithinWindow()
+}
+
+// SetTreeAt replaces the displayed tree like SetTree, placing the cursor on
+// the given line in the row of the view it is in.
+func (t *Terminal) SetTreeAt(tree *jsontree.JsonTree, virtualLn int) {
+	t.Tree = tree
+	t.OffsetY = max(0, virtualLn-t.CursorY)
+	t.CursorY = virtualLn - t.OffsetY
+}
+
 func (t *Terminal) Resize(width, height int) {
 	t.Width = width
 	t.Height = height
@@ -49,37 +102,98 @@ func (t *Terminal) Resize(width, height int) {
 
 func (t *Terminal) EnsureCursorWithinWindow() {
 	t.CursorX = min(t.Width-1, max(0, t.CursorX))
-	t.CursorY = min(t.Height-1, max(0, t.CursorY))
+	t.CursorY = min(t.viewHeight()-1, max(0, t.CursorY))
+}
+
+// viewHeight returns the number of rows available for the tree.
+func (t *Terminal) viewHeight() int {
+	if t.Status != "" || t.StatusRight != "" {
+		return t.Height - 1
+	}
+	return t.Height
 }
 
 func (t *Terminal) Render() {
 	termbox.Clear(termbox.ColorWhite, termbox.ColorDefault)
 
-	for y := 0; y < t.Height; y++ {
+	for y := 0; y < t.viewHeight(); y++ {
 		if line := t.Tree.Line(y + t.OffsetY); line != nil {
 			lineLen := len(line)
+			highlighted := t.highlighted(line)
 			for x := 0; x < t.Width && x+t.OffsetX < lineLen; x++ {
 				c := line[x+t.OffsetX]
-				termbox.SetCell(x, y, c.Val, c.Color, termbox.ColorDefault)
+				if highlighted != nil && highlighted[x+t.OffsetX] {
+					termbox.SetCell(x, y, c.Val, termbox.ColorBlack, termbox.ColorYellow)
+				} else {
+					termbox.SetCell(x, y, c.Val, c.Color, termbox.ColorDefault)
+				}
 			}
 		}
 	}
 
+	if t.viewHeight() < t.Height {
+		t.renderStatus()
+	}
+
 	termbox.SetCursor(t.CursorX, t.CursorY)
 	termbox.Flush()
 }
 
-func (t *Terminal) Poll() termbox.Event {
-	for {
-		switch e := termbox.PollEvent(); e.Type {
-		case termbox.EventKey:
-			return e
-		case termbox.EventResize:
-			t.Resize(e.Width, e.Height)
+// highlighted reports for every character of line whether it is part of a
+// match of Highlight, or returns nil if nothing is highlighted.
+func (t *Terminal) highlighted(line jsontree.Line) []bool {
+	if t.Highlight == nil {
+		return nil
+	}
+
+	text := line.String()
+	var highlighted []bool
+	for _, match := range t.Highlight.FindAllStringIndex(text, -1) {
+		if highlighted == nil {
+			highlighted = make([]bool, len(line))
+		}
+		from := utf8.RuneCountInString(text[:match[0]])
+		to := from + utf8.RuneCountInString(text[match[0]:match[1]])
+		for i := from; i < to; i++ {
+			highlighted[i] = true
+		}
+	}
+	return highlighted
+}
+
+// renderStatus draws the status in reverse video across the last row. The
+// left part is cut off where it would run into the right one.
+func (t *Terminal) renderStatus() {
+	left, right := []rune(t.Status), []rune(t.StatusRight)
+	rightX := t.Width - len(right)
+	for x := 0; x < t.Width; x++ {
+		c := ' '
+		switch {
+		case x >= rightX:
+			c = right[x-rightX]
+		case x < len(left) && x < rightX-1:
+			c = left[x]
 		}
+		termbox.SetCell(x, t.Height-1, c, termbox.ColorDefault|termbox.AttrReverse, termbox.ColorDefault)
 	}
 }
 
+// Events polls key and resize events in the background, so they can be
+// handled together with other work. Resize events are to be passed to
+// Resize.
+func (t *Terminal) Events() <-chan termbox.Event {
+	events := make(chan termbox.Event)
+	go func() {
+		for {
+			switch e := termbox.PollEvent(); e.Type {
+			case termbox.EventKey, termbox.EventResize:
+				events <- e
+			}
+		}
+	}()
+	return events
+}
+
 func (t *Terminal) Close() {
 	termbox.Close()
 }